@@ -0,0 +1,35 @@
+package redigosrv
+
+import "time"
+
+// backoff is an exponential backoff, doubling the delay until the maximum.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{
+		min: min,
+		max: max,
+	}
+}
+
+// next returns the delay before the next attempt.
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current *= 2
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// reset makes the next delay the minimum again.
+func (b *backoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,239 @@
+package redigosrv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNotFound is returned by a `CacheLoader` when the value does not exist.
+// If negative caching is enabled, it is cached and returned to the next
+// callers without calling the loader again.
+var ErrNotFound = errors.New("not found")
+
+// ErrLoaderPanicked is returned by `GetOrLoad` when the loader panicked.
+var ErrLoaderPanicked = errors.New("cache loader panicked")
+
+// notFoundEntry is the value of the negative entries: the envelope marker
+// followed by a tag reserved for the headers, so it is never written by a
+// codec.
+var notFoundEntry = []byte{envelopeMarker, 0x7f}
+
+// CacheOptions are the options of a `Cache`.
+type CacheOptions struct {
+	// TTL is the time to live of the entries.
+	TTL time.Duration
+	// Jitter is the fraction of the TTL randomly added to each entry, so
+	// entries written together do not expire together. For instance, 0.1
+	// adds up to 10% of the TTL.
+	Jitter float64
+	// NegativeTTL is the time to live of the entries not found by the loader.
+	// If zero, negative caching is disabled.
+	NegativeTTL time.Duration
+	// Codec serializes the values, defaults to the codec of the service.
+	Codec Codec
+}
+
+// CacheLoader loads a value missing from the cache.
+type CacheLoader func(ctx context.Context) (interface{}, error)
+
+// Cache is a cache-aside helper: values are read from Redis and, when
+// missing, loaded and written back. Concurrent misses for the same key, in
+// this process, call the loader only once.
+type Cache struct {
+	service *RedigoService
+	name    string
+	opts    CacheOptions
+	flights flightGroup
+}
+
+// NewCache returns a new `Cache`. The name is used as the prefix of the keys
+// and as the label of the metrics.
+func (service *RedigoService) NewCache(name string, opts CacheOptions) *Cache {
+	return &Cache{
+		service: service,
+		name:    name,
+		opts:    opts,
+	}
+}
+
+func (cache *Cache) key(key string) string {
+	return cache.name + ":" + key
+}
+
+// ttl returns the TTL with the random jitter added.
+func (cache *Cache) ttl() time.Duration {
+	ttl := cache.opts.TTL
+	if cache.opts.Jitter > 0 && ttl > 0 {
+		ttl += time.Duration(rand.Float64() * cache.opts.Jitter * float64(ttl))
+	}
+	return ttl
+}
+
+// Get reads a value into dst. It returns `ErrNil` when the key is not
+// cached and `ErrNotFound` when a negative entry is cached.
+func (cache *Cache) Get(ctx context.Context, key string, dst interface{}) error {
+	data, err := cache.service.GetBytes(ctx, cache.key(key))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, notFoundEntry) {
+		return ErrNotFound
+	}
+	return cache.service.Unmarshal(cache.opts.Codec, data, dst)
+}
+
+// Set writes a value to the cache.
+func (cache *Cache) Set(ctx context.Context, key string, value interface{}) error {
+	data, err := cache.service.Marshal(cache.opts.Codec, value)
+	if err != nil {
+		return err
+	}
+	return cache.service.Set(ctx, cache.key(key), data, cache.ttl())
+}
+
+// Delete removes values from the cache.
+func (cache *Cache) Delete(ctx context.Context, keys ...string) error {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = cache.key(key)
+	}
+	_, err := cache.service.Del(ctx, cacheKeys...)
+	return err
+}
+
+// GetOrLoad reads a value into dst. On a miss, the value is loaded, written
+// to the cache and then read into dst. Failing to reach Redis is handled as a
+// miss, so the loader still serves the value.
+//
+// The loader is shared by the concurrent misses of the key, so it is not
+// cancelled by the context of the caller that started it: each caller stops
+// waiting when its own context is done, while the loader keeps going for the
+// others with the values of the context but no deadline.
+func (cache *Cache) GetOrLoad(ctx context.Context, key string, dst interface{}, loader CacheLoader) error {
+	labels := prometheus.Labels{"cache": cache.name}
+
+	switch err := cache.Get(ctx, key, dst); {
+	case err == nil || err == ErrNotFound:
+		cache.service.Collector.cacheHits.With(labels).Inc()
+		return err
+	case err == ErrNil:
+	case ctx.Err() != nil:
+		return err
+	default:
+		// Redis is unreachable, or the entry is corrupted, so the value is
+		// served by the loader.
+		cache.service.logger().Printf("redigosrv: cache %s could not read %s: %s", cache.name, key, err)
+	}
+	cache.service.Collector.cacheMisses.With(labels).Inc()
+
+	data, err := cache.flights.do(ctx, cache.key(key), func() (data []byte, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				cache.service.logger().Printf("redigosrv: cache %s loader panicked on %s: %v", cache.name, key, r)
+				err = ErrLoaderPanicked
+			}
+		}()
+		return cache.load(detachedContext{ctx}, key, loader)
+	})
+	if err != nil {
+		return err
+	}
+	return cache.service.Unmarshal(cache.opts.Codec, data, dst)
+}
+
+// load calls the loader and writes the value to the cache.
+func (cache *Cache) load(ctx context.Context, key string, loader CacheLoader) ([]byte, error) {
+	start := time.Now()
+	value, err := loader(ctx)
+	cache.service.Collector.cacheLoadDuration.With(prometheus.Labels{"cache": cache.name}).Observe(time.Since(start).Seconds())
+
+	if err == ErrNotFound && cache.opts.NegativeTTL > 0 {
+		if err := cache.service.Set(ctx, cache.key(key), notFoundEntry, cache.opts.NegativeTTL); err != nil {
+			cache.service.logger().Printf("redigosrv: cache %s could not write %s: %s", cache.name, key, err)
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cache.service.Marshal(cache.opts.Codec, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.service.Set(ctx, cache.key(key), data, cache.ttl()); err != nil {
+		cache.service.logger().Printf("redigosrv: cache %s could not write %s: %s", cache.name, key, err)
+	}
+	return data, nil
+}
+
+// flightGroup makes concurrent calls with the same key share the result of a
+// single call.
+type flightGroup struct {
+	sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// do calls fn, unless a call with the same key is in flight, and waits for
+// its result or for the context to be done. The call is not cancelled when
+// the callers stop waiting.
+func (group *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, error)) ([]byte, error) {
+	group.Lock()
+	f, ok := group.flights[key]
+	if !ok {
+		if group.flights == nil {
+			group.flights = make(map[string]*flight)
+		}
+		f = &flight{done: make(chan struct{})}
+		group.flights[key] = f
+		go group.run(key, f, fn)
+	}
+	group.Unlock()
+
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (group *flightGroup) run(key string, f *flight, fn func() ([]byte, error)) {
+	defer func() {
+		group.Lock()
+		delete(group.flights, key)
+		group.Unlock()
+		close(f.done)
+	}()
+	f.data, f.err = fn()
+}
+
+// detachedContext keeps the values of a context, but not its deadline nor
+// its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
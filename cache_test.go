@@ -0,0 +1,212 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type cacheTestUser struct {
+	Name string `json:"name"`
+}
+
+var _ = Describe("Cache", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "users:1", "users:2")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should load on a miss and read from the cache on a hit", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL: time.Minute,
+		})
+
+		calls := 0
+		loader := func(ctx context.Context) (interface{}, error) {
+			calls++
+			return cacheTestUser{Name: "john"}, nil
+		}
+
+		var user cacheTestUser
+		Expect(cache.GetOrLoad(ctx, "1", &user, loader)).To(Succeed())
+		Expect(user.Name).To(Equal("john"))
+
+		user = cacheTestUser{}
+		Expect(cache.GetOrLoad(ctx, "1", &user, loader)).To(Succeed())
+		Expect(user.Name).To(Equal("john"))
+		Expect(calls).To(Equal(1))
+		Expect(service.TTL(ctx, "users:1")).To(BeNumerically("~", time.Minute, time.Second))
+
+		var metric dto.Metric
+		Expect(service.Collector.cacheHits.With(prometheus.Labels{"cache": "users"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+		Expect(service.Collector.cacheMisses.With(prometheus.Labels{"cache": "users"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should add jitter to the TTL", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL:    time.Minute,
+			Jitter: 0.5,
+		})
+		for i := 0; i < 10; i++ {
+			Expect(cache.ttl()).To(BeNumerically(">=", time.Minute))
+			Expect(cache.ttl()).To(BeNumerically("<=", 90*time.Second))
+		}
+	})
+
+	It("should load only once for concurrent misses", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL: time.Minute,
+		})
+
+		var calls int32
+		loader := func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+			return cacheTestUser{Name: "john"}, nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				var user cacheTestUser
+				Expect(cache.GetOrLoad(ctx, "2", &user, loader)).To(Succeed())
+				Expect(user.Name).To(Equal("john"))
+			}()
+		}
+		wg.Wait()
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("should not block the next calls when the loader panics", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL: time.Minute,
+		})
+
+		var user cacheTestUser
+		Expect(cache.GetOrLoad(ctx, "1", &user, func(ctx context.Context) (interface{}, error) {
+			panic("something bad")
+		})).To(Equal(ErrLoaderPanicked))
+
+		Expect(cache.GetOrLoad(ctx, "1", &user, func(ctx context.Context) (interface{}, error) {
+			return cacheTestUser{Name: "john"}, nil
+		})).To(Succeed())
+		Expect(user.Name).To(Equal("john"))
+	})
+
+	It("should keep loading for the others when a caller gives up", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL: time.Minute,
+		})
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		loader := func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-release
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return cacheTestUser{Name: "john"}, nil
+		}
+
+		first, cancel := context.WithCancel(ctx)
+		errs := make(chan error, 1)
+		go func() {
+			var user cacheTestUser
+			errs <- cache.GetOrLoad(first, "2", &user, loader)
+		}()
+		<-started
+
+		loaded := make(chan cacheTestUser, 1)
+		go func() {
+			defer GinkgoRecover()
+			var user cacheTestUser
+			Expect(cache.GetOrLoad(ctx, "2", &user, loader)).To(Succeed())
+			loaded <- user
+		}()
+
+		cancel()
+		Eventually(errs).Should(Receive(Equal(context.Canceled)))
+		close(release)
+		Eventually(loaded).Should(Receive(Equal(cacheTestUser{Name: "john"})))
+	})
+
+	It("should cache values not found", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL:         time.Minute,
+			NegativeTTL: time.Minute,
+		})
+
+		calls := 0
+		loader := func(ctx context.Context) (interface{}, error) {
+			calls++
+			return nil, ErrNotFound
+		}
+
+		var user cacheTestUser
+		Expect(cache.GetOrLoad(ctx, "1", &user, loader)).To(Equal(ErrNotFound))
+		Expect(cache.GetOrLoad(ctx, "1", &user, loader)).To(Equal(ErrNotFound))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should cache empty values", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL:         time.Minute,
+			NegativeTTL: time.Minute,
+			Codec:       rawTestCodec{},
+		})
+
+		Expect(cache.Set(ctx, "1", []byte{})).To(Succeed())
+		var value []byte
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(value).To(BeEmpty())
+	})
+
+	It("should propagate errors from the loader", func() {
+		cache := service.NewCache("users", CacheOptions{
+			TTL: time.Minute,
+		})
+
+		var user cacheTestUser
+		err := cache.GetOrLoad(ctx, "1", &user, func(ctx context.Context) (interface{}, error) {
+			return nil, errors.New("something bad")
+		})
+		Expect(err).To(MatchError("something bad"))
+		Expect(cache.Get(ctx, "1", &user)).To(Equal(ErrNil))
+	})
+
+	It("should set and delete values", func() {
+		cache := service.NewCache("users", CacheOptions{})
+		Expect(cache.Set(ctx, "1", cacheTestUser{Name: "jane"})).To(Succeed())
+
+		var user cacheTestUser
+		Expect(cache.Get(ctx, "1", &user)).To(Succeed())
+		Expect(user.Name).To(Equal("jane"))
+
+		Expect(cache.Delete(ctx, "1")).To(Succeed())
+		Expect(cache.Get(ctx, "1", &user)).To(Equal(ErrNil))
+	})
+})
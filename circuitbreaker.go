@@ -0,0 +1,182 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker is open and the
+// request was rejected without touching the pool.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState represents the state of the circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed means requests flow normally.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means requests are rejected with `ErrCircuitOpen`.
+	CircuitOpen
+	// CircuitHalfOpen means a limited number of trial requests are allowed
+	// to probe if Redis is back.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfiguration is the configuration for the circuit breaker
+// that protects the pool when Redis is unreachable.
+type CircuitBreakerConfiguration struct {
+	Enabled bool `yaml:"enabled"`
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit.
+	FailureThreshold int `yaml:"failure_threshold"`
+	// OpenTimeout is how long the circuit stays open before allowing trials.
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+	// HalfOpenMaxTrials is the number of concurrent trials allowed while the
+	// circuit is half-open.
+	HalfOpenMaxTrials int `yaml:"half_open_max_trials"`
+}
+
+type circuitBreaker struct {
+	sync.Mutex
+	config        CircuitBreakerConfiguration
+	state         CircuitState
+	failures      int
+	trials        int
+	openedAt      time.Time
+	now           func() time.Time
+	onStateChange func(state CircuitState)
+}
+
+func newCircuitBreaker(config CircuitBreakerConfiguration, onStateChange func(state CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		config:        config,
+		now:           time.Now,
+		onStateChange: onStateChange,
+	}
+}
+
+// allow checks if a request can go through. When it returns nil, the caller
+// must report the outcome using `success` or `failure`.
+func (breaker *circuitBreaker) allow() error {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		if breaker.now().Sub(breaker.openedAt) < breaker.config.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		breaker.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if breaker.trials >= breaker.config.HalfOpenMaxTrials {
+			return ErrCircuitOpen
+		}
+		breaker.trials++
+	}
+	return nil
+}
+
+// success reports a successful request, closing the circuit if it was
+// half-open.
+func (breaker *circuitBreaker) success() {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.failures = 0
+	if breaker.state == CircuitHalfOpen {
+		breaker.setState(CircuitClosed)
+	}
+}
+
+// failure reports a failed request, opening the circuit when the threshold
+// is reached or when a trial fails.
+func (breaker *circuitBreaker) failure() {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.failures++
+	if breaker.state == CircuitHalfOpen || breaker.failures >= breaker.config.FailureThreshold {
+		breaker.openedAt = breaker.now()
+		breaker.setState(CircuitOpen)
+	}
+}
+
+// release gives back a request allowed by `allow` whose outcome is unknown,
+// so it is not counted as a success nor as a failure.
+func (breaker *circuitBreaker) release() {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	if breaker.state == CircuitHalfOpen && breaker.trials > 0 {
+		breaker.trials--
+	}
+}
+
+func (breaker *circuitBreaker) currentState() CircuitState {
+	breaker.Lock()
+	defer breaker.Unlock()
+	return breaker.state
+}
+
+// setState must be called with the lock held.
+func (breaker *circuitBreaker) setState(state CircuitState) {
+	breaker.trials = 0
+	if breaker.state == state {
+		return
+	}
+	breaker.state = state
+	if breaker.onStateChange != nil {
+		breaker.onStateChange(state)
+	}
+}
+
+// CircuitState returns the current state of the circuit breaker. If the
+// circuit breaker is disabled, it is always closed.
+func (service *RedigoService) CircuitState() CircuitState {
+	if service.breaker == nil {
+		return CircuitClosed
+	}
+	return service.breaker.currentState()
+}
+
+// breakerAllow checks the circuit breaker, if enabled.
+func (service *RedigoService) breakerAllow() error {
+	if service.breaker == nil {
+		return nil
+	}
+	return service.breaker.allow()
+}
+
+// breakerReport reports the outcome of a request allowed by `breakerAllow`.
+// Only connection errors count as failures, replies errors from Redis do
+// not. Neither does the caller giving up when its context is done.
+func (service *RedigoService) breakerReport(connErr error) {
+	if service.breaker == nil {
+		return
+	}
+	if connErr == context.Canceled || connErr == context.DeadlineExceeded {
+		service.breaker.release()
+		return
+	}
+	if connErr != nil {
+		service.breaker.failure()
+		return
+	}
+	service.breaker.success()
+}
@@ -0,0 +1,141 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		now     time.Time
+		states  []CircuitState
+		breaker *circuitBreaker
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		states = nil
+		breaker = newCircuitBreaker(CircuitBreakerConfiguration{
+			Enabled:           true,
+			FailureThreshold:  2,
+			OpenTimeout:       time.Second,
+			HalfOpenMaxTrials: 1,
+		}, func(state CircuitState) {
+			states = append(states, state)
+		})
+		breaker.now = func() time.Time {
+			return now
+		}
+	})
+
+	It("should open after reaching the failure threshold", func() {
+		Expect(breaker.allow()).To(Succeed())
+		breaker.failure()
+		Expect(breaker.currentState()).To(Equal(CircuitClosed))
+		Expect(breaker.allow()).To(Succeed())
+		breaker.failure()
+		Expect(breaker.currentState()).To(Equal(CircuitOpen))
+		Expect(breaker.allow()).To(Equal(ErrCircuitOpen))
+		Expect(states).To(Equal([]CircuitState{CircuitOpen}))
+	})
+
+	It("should reset the failures after a success", func() {
+		breaker.failure()
+		breaker.success()
+		breaker.failure()
+		Expect(breaker.currentState()).To(Equal(CircuitClosed))
+	})
+
+	It("should close after a successful trial", func() {
+		breaker.failure()
+		breaker.failure()
+		now = now.Add(time.Second)
+		Expect(breaker.allow()).To(Succeed())
+		Expect(breaker.currentState()).To(Equal(CircuitHalfOpen))
+		Expect(breaker.allow()).To(Equal(ErrCircuitOpen))
+		breaker.success()
+		Expect(breaker.currentState()).To(Equal(CircuitClosed))
+		Expect(states).To(Equal([]CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}))
+	})
+
+	It("should open again after a failed trial", func() {
+		breaker.failure()
+		breaker.failure()
+		now = now.Add(time.Second)
+		Expect(breaker.allow()).To(Succeed())
+		breaker.failure()
+		Expect(breaker.currentState()).To(Equal(CircuitOpen))
+		Expect(breaker.allow()).To(Equal(ErrCircuitOpen))
+	})
+
+	It("should give back a trial without an outcome", func() {
+		breaker.failure()
+		breaker.failure()
+		now = now.Add(time.Second)
+		Expect(breaker.allow()).To(Succeed())
+		breaker.release()
+		Expect(breaker.currentState()).To(Equal(CircuitHalfOpen))
+		Expect(breaker.allow()).To(Succeed())
+	})
+
+	It("should not count the context errors as failures", func() {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			CircuitBreaker: CircuitBreakerConfiguration{
+				Enabled:          true,
+				FailureThreshold: 1,
+				OpenTimeout:      time.Minute,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+
+		// The only connection of the pool is busy, so it times out waiting.
+		service.pool.MaxActive = 1
+		service.pool.Wait = true
+		conn, err := service.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Expect(service.RunWithConnContext(ctx, pingConnection)).To(Equal(context.DeadlineExceeded))
+		Expect(service.CircuitState()).To(Equal(CircuitClosed))
+	})
+
+	It("should fail fast when Redis is down", func() {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			CircuitBreaker: CircuitBreakerConfiguration{
+				Enabled:          true,
+				FailureThreshold: 2,
+				OpenTimeout:      time.Minute,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+		Expect(service.RunWithConn(pingConnection)).To(Succeed())
+
+		service.Configuration.Address = "localhost:1"
+		noop := func(conn redis.ConnWithTimeout) error {
+			return nil
+		}
+		Expect(service.RunWithConn(noop)).ToNot(Equal(ErrCircuitOpen))
+		Expect(service.RunWithConn(noop)).ToNot(Equal(ErrCircuitOpen))
+		Expect(service.RunWithConn(noop)).To(Equal(ErrCircuitOpen))
+		_, err := service.GetConn()
+		Expect(err).To(Equal(ErrCircuitOpen))
+		Expect(service.CircuitState()).To(Equal(CircuitOpen))
+
+		var metric dto.Metric
+		Expect(service.Collector.circuitBreakerState.Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(CircuitOpen)))
+	})
+})
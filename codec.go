@@ -0,0 +1,270 @@
+package redigosrv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// ErrUnknownCodec is returned when no codec was registered with the given
+// name or content type.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// ErrNotProtoMessage is returned by `ProtobufCodec` when the value is not a
+// `proto.Message`.
+var ErrNotProtoMessage = errors.New("value is not a proto.Message")
+
+// ContentType identifies the codec of a payload in its content-type marker.
+type ContentType byte
+
+// The content types of the built in codecs, below 64. Custom codecs should
+// use values from 128 on, as the values from 64 to 127 are reserved for the
+// compression, encryption and negative cache headers.
+const (
+	ContentTypeJSON     ContentType = 1
+	ContentTypeMsgPack  ContentType = 2
+	ContentTypeProtobuf ContentType = 3
+	ContentTypeGob      ContentType = 4
+)
+
+// envelopeMarker is the first byte of the payloads with a content-type marker,
+// followed by the `ContentType`, or with a compression or encryption header.
+// It is never used by MessagePack, is invalid in UTF-8 (so JSON) and is not a
+// valid length for gob.
+const envelopeMarker = 0xc1
+
+// Codec serializes values written to and read from Redis.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// Name identifies the codec in the `SerializationConfiguration`.
+	Name() string
+	// ContentType identifies the codec in the content-type marker.
+	ContentType() ContentType
+}
+
+// SerializationConfiguration is the configuration of how the service
+// serializes values.
+type SerializationConfiguration struct {
+	// Codec is the name of the default codec. Defaults to "json".
+	Codec string `yaml:"codec"`
+	// ContentTypeMarker prefixes the serialized values with the content type
+	// of their codec, so consumers can decode payloads serialized with
+	// different codecs. The marker is only read when enabled, so both the
+	// producers and the consumers must enable it.
+	ContentTypeMarker bool `yaml:"content_type_marker"`
+}
+
+var (
+	// JSONCodec serializes values using `encoding/json`.
+	JSONCodec Codec = jsonCodec{}
+	// MsgPackCodec serializes values using MessagePack.
+	MsgPackCodec Codec = msgPackCodec{}
+	// ProtobufCodec serializes values implementing `proto.Message`.
+	ProtobufCodec Codec = protobufCodec{}
+	// GobCodec serializes values using `encoding/gob`.
+	GobCodec Codec = gobCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	byName        map[string]Codec
+	byContentType map[ContentType]Codec
+}{
+	byName:        make(map[string]Codec),
+	byContentType: make(map[ContentType]Codec),
+}
+
+func init() {
+	for _, codec := range []Codec{JSONCodec, MsgPackCodec, ProtobufCodec, GobCodec} {
+		RegisterCodec(codec)
+	}
+}
+
+// RegisterCodec registers a codec, so it can be configured by name and
+// payloads marked with its content type can be decoded. It replaces any codec
+// registered with the same name or content type.
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byName[codec.Name()] = codec
+	codecs.byContentType[codec.ContentType()] = codec
+}
+
+// CodecByName returns the codec registered with the name.
+func CodecByName(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.byName[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+func codecByContentType(contentType ContentType) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.byContentType[contentType]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+// codec returns the given codec or, if nil, the configured one.
+func (service *RedigoService) codec(codec Codec) Codec {
+	if codec != nil {
+		return codec
+	}
+	if codec, err := CodecByName(service.Configuration.Serialization.Codec); err == nil {
+		return codec
+	}
+	return JSONCodec
+}
+
+// Marshal serializes the value using the codec, or the configured one if nil,
+// adding the content-type marker, compressing and encrypting it when enabled.
+func (service *RedigoService) Marshal(codec Codec, v interface{}) ([]byte, error) {
+	codec = service.codec(codec)
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if service.Configuration.Serialization.ContentTypeMarker {
+		data = append([]byte{envelopeMarker, byte(codec.ContentType())}, data...)
+	}
+	return service.wrap(data)
+}
+
+// Unmarshal deserializes the data into v, decrypting and decompressing it if
+// needed. If the content-type marker is enabled and the data has one, the
+// codec of its content type is used. Otherwise, the given codec, or the
+// configured one if nil, is used.
+func (service *RedigoService) Unmarshal(codec Codec, data []byte, v interface{}) error {
+	data, err := service.unwrap(data)
+	if err != nil {
+		return err
+	}
+	return service.decode(codec, data, v)
+}
+
+// decode deserializes data already decrypted and decompressed, as
+// `Unmarshal` does.
+func (service *RedigoService) decode(codec Codec, data []byte, v interface{}) error {
+	if service.Configuration.Serialization.ContentTypeMarker && len(data) >= 2 && data[0] == envelopeMarker {
+		c, err := codecByContentType(ContentType(data[1]))
+		if err != nil {
+			return err
+		}
+		codec, data = c, data[2:]
+	}
+	return service.codec(codec).Unmarshal(data, v)
+}
+
+// wrap compresses and then encrypts the data when enabled.
+func (service *RedigoService) wrap(data []byte) ([]byte, error) {
+	data, err := service.compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return service.encrypt(data)
+}
+
+// unwrap decrypts and then decompresses the data if needed.
+func (service *RedigoService) unwrap(data []byte) ([]byte, error) {
+	data, err := service.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return service.decompress(data)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ContentType() ContentType {
+	return ContentTypeJSON
+}
+
+type msgPackCodec struct{}
+
+func (msgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgPackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgPackCodec) ContentType() ContentType {
+	return ContentTypeMsgPack
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, message)
+}
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) ContentType() ContentType {
+	return ContentTypeProtobuf
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) ContentType() ContentType {
+	return ContentTypeGob
+}
@@ -0,0 +1,122 @@
+package redigosrv
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type codecValue struct {
+	Name  string
+	Count int
+}
+
+// rawTestCodec reads the payloads as they are.
+type rawTestCodec struct{}
+
+func (rawTestCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawTestCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = data
+	return nil
+}
+
+func (rawTestCodec) Name() string {
+	return "raw"
+}
+
+func (rawTestCodec) ContentType() ContentType {
+	return 128
+}
+
+var _ = Describe("Codec", func() {
+	var service RedigoService
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+	})
+
+	It("should default to JSON", func() {
+		Expect(service.Configuration.Serialization.Codec).To(Equal("json"))
+		Expect(service.Marshal(nil, codecValue{"a", 1})).To(Equal([]byte(`{"Name":"a","Count":1}`)))
+	})
+
+	It("should fail with an unknown codec", func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Serialization: SerializationConfiguration{
+				Codec: "xml",
+			},
+		})).To(Equal(ErrUnknownCodec))
+	})
+
+	It("should serialize values with the built in codecs", func() {
+		for _, codec := range []Codec{JSONCodec, MsgPackCodec, GobCodec} {
+			data, err := service.Marshal(codec, codecValue{"a", 1})
+			Expect(err).ToNot(HaveOccurred())
+
+			var value codecValue
+			Expect(service.Unmarshal(codec, data, &value)).To(Succeed())
+			Expect(value).To(Equal(codecValue{"a", 1}))
+		}
+
+		data, err := service.Marshal(ProtobufCodec, &wrappers.StringValue{Value: "a"})
+		Expect(err).ToNot(HaveOccurred())
+		var message wrappers.StringValue
+		Expect(service.Unmarshal(ProtobufCodec, data, &message)).To(Succeed())
+		Expect(proto.Equal(&message, &wrappers.StringValue{Value: "a"})).To(BeTrue())
+
+		_, err = service.Marshal(ProtobufCodec, codecValue{})
+		Expect(err).To(Equal(ErrNotProtoMessage))
+	})
+
+	It("should decode mixed payloads using the content-type marker", func() {
+		service.Configuration.Serialization.ContentTypeMarker = true
+
+		data, err := service.Marshal(MsgPackCodec, codecValue{"a", 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data[:2]).To(Equal([]byte{envelopeMarker, byte(ContentTypeMsgPack)}))
+
+		var value codecValue
+		Expect(service.Unmarshal(nil, data, &value)).To(Succeed())
+		Expect(value).To(Equal(codecValue{"a", 1}))
+
+		// Payloads without the marker use the given codec.
+		value = codecValue{}
+		Expect(service.Unmarshal(nil, []byte(`{"Name":"b"}`), &value)).To(Succeed())
+		Expect(value).To(Equal(codecValue{Name: "b"}))
+
+		Expect(service.Unmarshal(nil, []byte{envelopeMarker, 200, 1}, &value)).To(Equal(ErrUnknownCodec))
+	})
+
+	It("should not read the content-type marker when disabled", func() {
+		// Raw payloads, like protobuf ones, can start with the marker.
+		data := []byte{envelopeMarker, byte(ContentTypeJSON), 2}
+		var value []byte
+		Expect(service.Unmarshal(rawTestCodec{}, data, &value)).To(Succeed())
+		Expect(value).To(Equal(data))
+	})
+
+	It("should set and get values using the codec", func() {
+		ctx := context.Background()
+		service.Configuration.Serialization.Codec = "msgpack"
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+
+		Expect(service.SetValue(ctx, "codec-value", codecValue{"a", 1}, 0, nil)).To(Succeed())
+		var value codecValue
+		Expect(service.GetValue(ctx, "codec-value", &value, nil)).To(Succeed())
+		Expect(value).To(Equal(codecValue{"a", 1}))
+
+		Expect(service.GetValue(ctx, "codec-value", &value, JSONCodec)).To(HaveOccurred())
+		Expect(service.Del(ctx, "codec-value")).To(Equal(int64(1)))
+		Expect(service.GetValue(ctx, "codec-value", &value, nil)).To(Equal(ErrNil))
+	})
+})
@@ -15,10 +15,33 @@ type RedigoCollector struct {
 	publishTrafficSize    prometheus.Counter
 	subscribeSuccesses    prometheus.Counter
 	subscribeFailures     prometheus.Counter
+	subscribeReconnects   prometheus.Counter
+	subscribeDropped      prometheus.Counter
+	handlerErrors         *prometheus.CounterVec
+	dispatchQueueDepth    prometheus.Gauge
+	subscriberQueueDepth  prometheus.Gauge
+	dispatchDuration      prometheus.Histogram
 	commandCalls          *prometheus.CounterVec
 	methodDuration        *prometheus.CounterVec
 	poolActiveConnections *prometheus.Desc
 	poolIdleConnections   *prometheus.Desc
+	circuitBreakerState   prometheus.Gauge
+	connectionsLeaked     prometheus.Counter
+	transactionConflicts  prometheus.Counter
+	lockWaitDuration      prometheus.Histogram
+	lockContentions       prometheus.Counter
+	lockLost              prometheus.Counter
+	rateLimitDecisions    *prometheus.CounterVec
+	cacheHits             *prometheus.CounterVec
+	cacheMisses           *prometheus.CounterVec
+	cacheLoadDuration     *prometheus.HistogramVec
+	nearCacheHits         *prometheus.CounterVec
+	nearCacheInvalidated  *prometheus.CounterVec
+	compressionRawBytes   prometheus.Counter
+	compressionWireBytes  prometheus.Counter
+	queueDepth            *prometheus.GaugeVec
+	streamLag             *prometheus.GaugeVec
+	streamPending         *prometheus.GaugeVec
 }
 
 type PoolStats interface {
@@ -72,6 +95,30 @@ func NewRedigoCollector(pool PoolStats, opts RedigoCollectorOptions) *RedigoColl
 			Name: fmt.Sprintf("redigo_%ssubscribe_failures", prefix),
 			Help: "Total of failed when call Subscribed",
 		}),
+		subscribeReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%ssubscribe_reconnects", prefix),
+			Help: "Total of subscriptions subscribed again after their connection failed",
+		}),
+		subscribeDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%ssubscribe_dropped", prefix),
+			Help: "Total of messages dropped because the buffer of the subscription was full",
+		}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%ssubscribe_handler_errors", prefix),
+			Help: "Total of errors of the subscription handlers by action (retry, stop, log or dead_letter)",
+		}, []string{"action"}),
+		dispatchQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%sdispatch_queue_depth", prefix),
+			Help: "Number of messages waiting for the workers of the dispatchers",
+		}),
+		subscriberQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%ssubscriber_queue_depth", prefix),
+			Help: "Number of messages waiting for the handlers of the subscribers",
+		}),
+		dispatchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: fmt.Sprintf("redigo_%sdispatch_duration", prefix),
+			Help: "Time taken by the handlers of the dispatchers, in seconds",
+		}),
 		commandCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: fmt.Sprintf("redigo_%scommand_calls", prefix),
 			Help: "Total of command calls (Success or failures)",
@@ -82,6 +129,74 @@ func NewRedigoCollector(pool PoolStats, opts RedigoCollectorOptions) *RedigoColl
 		}, redigoMetricsLabels),
 		poolActiveConnections: prometheus.NewDesc(fmt.Sprintf("redigo_%spool_active_connections", prefix), "The number of connections actived in pool (used or not).", nil, nil),
 		poolIdleConnections:   prometheus.NewDesc(fmt.Sprintf("redigo_%spool_idle_connections", prefix), "The number of idle connections in the pool.", nil, nil),
+		circuitBreakerState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%scircuit_breaker_state", prefix),
+			Help: "Current state of the circuit breaker (0 closed, 1 open, 2 half-open)",
+		}),
+		connectionsLeaked: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%sconnections_leaked", prefix),
+			Help: "Total of connections held longer than the leak detection threshold",
+		}),
+		transactionConflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%stransaction_conflicts", prefix),
+			Help: "Total of transactions aborted because a watched key changed",
+		}),
+		lockWaitDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: fmt.Sprintf("redigo_%slock_wait_duration", prefix),
+			Help: "Time waited to acquire locks, in seconds",
+		}),
+		lockContentions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%slock_contentions", prefix),
+			Help: "Total of attempts to acquire a lock held by someone else",
+		}),
+		lockLost: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%slock_lost", prefix),
+			Help: "Total of locks lost because their lease could not be extended",
+		}),
+		rateLimitDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%sratelimit_decisions", prefix),
+			Help: "Total of requests allowed or denied by the rate limiters",
+		}, []string{"limiter", "decision"}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%scache_hits", prefix),
+			Help: "Total of values found in the cache",
+		}, []string{"cache"}),
+		cacheMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%scache_misses", prefix),
+			Help: "Total of values missing from the cache",
+		}, []string{"cache"}),
+		cacheLoadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: fmt.Sprintf("redigo_%scache_load_duration", prefix),
+			Help: "Time spent loading values missing from the cache, in seconds",
+		}, []string{"cache"}),
+		nearCacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%snear_cache_hits", prefix),
+			Help: "Total of values found in the memory of near caches",
+		}, []string{"cache"}),
+		nearCacheInvalidated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%snear_cache_invalidations", prefix),
+			Help: "Total of keys invalidated in near caches",
+		}, []string{"cache"}),
+		compressionRawBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%scompression_raw_bytes", prefix),
+			Help: "Total of bytes of the values compressed, before compressing",
+		}),
+		compressionWireBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: fmt.Sprintf("redigo_%scompression_compressed_bytes", prefix),
+			Help: "Total of bytes of the values compressed, after compressing",
+		}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%squeue_depth", prefix),
+			Help: "Number of jobs in the queues by state (pending, processing or dead)",
+		}, []string{"queue", "state"}),
+		streamLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%sstream_lag", prefix),
+			Help: "Number of entries not yet delivered to the consumer groups",
+		}, []string{"stream", "group"}),
+		streamPending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: fmt.Sprintf("redigo_%sstream_pending", prefix),
+			Help: "Number of entries delivered but not acknowledged by the consumer groups",
+		}, []string{"stream", "group"}),
 	}
 
 }
@@ -94,7 +209,30 @@ func (collector *RedigoCollector) Describe(desc chan<- *prometheus.Desc) {
 	collector.subscriptionsActive.Describe(desc)
 	collector.subscribeSuccesses.Describe(desc)
 	collector.subscribeFailures.Describe(desc)
+	collector.subscribeReconnects.Describe(desc)
+	collector.subscribeDropped.Describe(desc)
+	collector.handlerErrors.Describe(desc)
+	collector.dispatchQueueDepth.Describe(desc)
+	collector.subscriberQueueDepth.Describe(desc)
+	collector.dispatchDuration.Describe(desc)
 	collector.publishTrafficSize.Describe(desc)
+	collector.circuitBreakerState.Describe(desc)
+	collector.connectionsLeaked.Describe(desc)
+	collector.transactionConflicts.Describe(desc)
+	collector.lockWaitDuration.Describe(desc)
+	collector.lockContentions.Describe(desc)
+	collector.lockLost.Describe(desc)
+	collector.rateLimitDecisions.Describe(desc)
+	collector.cacheHits.Describe(desc)
+	collector.cacheMisses.Describe(desc)
+	collector.cacheLoadDuration.Describe(desc)
+	collector.nearCacheHits.Describe(desc)
+	collector.nearCacheInvalidated.Describe(desc)
+	collector.compressionRawBytes.Describe(desc)
+	collector.compressionWireBytes.Describe(desc)
+	collector.queueDepth.Describe(desc)
+	collector.streamLag.Describe(desc)
+	collector.streamPending.Describe(desc)
 }
 
 // Collect provides metrics to prometheus
@@ -106,5 +244,33 @@ func (collector *RedigoCollector) Collect(metrics chan<- prometheus.Metric) {
 	collector.subscriptionsActive.Collect(metrics)
 	collector.subscribeSuccesses.Collect(metrics)
 	collector.subscribeFailures.Collect(metrics)
+	collector.subscribeReconnects.Collect(metrics)
+	collector.subscribeDropped.Collect(metrics)
+	collector.handlerErrors.Collect(metrics)
+	collector.dispatchQueueDepth.Collect(metrics)
+	collector.subscriberQueueDepth.Collect(metrics)
+	collector.dispatchDuration.Collect(metrics)
 	collector.publishTrafficSize.Collect(metrics)
+	collector.circuitBreakerState.Collect(metrics)
+	collector.connectionsLeaked.Collect(metrics)
+	collector.transactionConflicts.Collect(metrics)
+	collector.lockWaitDuration.Collect(metrics)
+	collector.lockContentions.Collect(metrics)
+	collector.lockLost.Collect(metrics)
+	collector.rateLimitDecisions.Collect(metrics)
+	collector.cacheHits.Collect(metrics)
+	collector.cacheMisses.Collect(metrics)
+	collector.cacheLoadDuration.Collect(metrics)
+	collector.nearCacheHits.Collect(metrics)
+	collector.nearCacheInvalidated.Collect(metrics)
+	collector.compressionRawBytes.Collect(metrics)
+	collector.compressionWireBytes.Collect(metrics)
+	collector.queueDepth.Collect(metrics)
+	collector.streamLag.Collect(metrics)
+	collector.streamPending.Collect(metrics)
+}
+
+// setCircuitState updates the circuit breaker state gauge.
+func (collector *RedigoCollector) setCircuitState(state CircuitState) {
+	collector.circuitBreakerState.Set(float64(state))
 }
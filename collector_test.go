@@ -126,9 +126,14 @@ var _ = Describe("RedigoCollector", func() {
 			return nil
 		}, "test-04")
 
+		// The handler errors are counted apart.
 		Expect(service.Collector.subscribeFailures.Write(&metric)).To(BeNil())
 
-		Expect(metric.GetCounter().GetValue()).To(Equal(float64(2)))
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+
+		Expect(service.Collector.handlerErrors.With(prometheus.Labels{"action": "stop"}).Write(&metric)).To(BeNil())
+
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
 		close(done)
 	})
 
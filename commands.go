@@ -0,0 +1,305 @@
+package redigosrv
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNil is returned by the command helpers when the key (or field, or
+// member) does not exist.
+var ErrNil = redis.ErrNil
+
+// NoExpiration is returned by `TTL` when the key exists but has no expiration
+// set.
+const NoExpiration time.Duration = -1
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// doContext sends the command honoring the deadline of the context.
+func doContext(ctx context.Context, conn redis.ConnWithTimeout, commandName string, args ...interface{}) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		return conn.DoWithTimeout(timeout, commandName, args...)
+	}
+	return conn.Do(commandName, args...)
+}
+
+// Do acquires a connection from the pool and sends a command to the server
+// returning the received reply.
+func (service *RedigoService) Do(ctx context.Context, commandName string, args ...interface{}) (reply interface{}, err error) {
+	err = service.RunWithConnContext(ctx, func(conn redis.ConnWithTimeout) error {
+		reply, err = doContext(ctx, conn, commandName, args...)
+		return err
+	})
+	return reply, err
+}
+
+// expirationArgs appends the PX argument when the ttl is positive.
+func expirationArgs(args redis.Args, ttl time.Duration) redis.Args {
+	if ttl > 0 {
+		return args.Add("PX", int64(ttl/time.Millisecond))
+	}
+	return args
+}
+
+// Get returns the value of a key, or `ErrNil` if it does not exist.
+func (service *RedigoService) Get(ctx context.Context, key string) (string, error) {
+	return redis.String(service.Do(ctx, "GET", key))
+}
+
+// GetBytes returns the value of a key as a byte slice, or `ErrNil` if it does
+// not exist.
+func (service *RedigoService) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return redis.Bytes(service.Do(ctx, "GET", key))
+}
+
+// Set sets the value of a key. If the ttl is positive, the key expires after
+// it.
+func (service *RedigoService) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	_, err := service.Do(ctx, "SET", expirationArgs(redis.Args{key, value}, ttl)...)
+	return err
+}
+
+// GetValue reads the value of a key into v, deserializing it with the codec,
+// or the configured one if nil. It returns `ErrNil` if it does not exist.
+func (service *RedigoService) GetValue(ctx context.Context, key string, v interface{}, codec Codec) error {
+	data, err := service.GetBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	return service.Unmarshal(codec, data, v)
+}
+
+// SetValue sets the value of a key serialized with the codec, or the
+// configured one if nil. If the ttl is positive, the key expires after it.
+func (service *RedigoService) SetValue(ctx context.Context, key string, v interface{}, ttl time.Duration, codec Codec) error {
+	data, err := service.Marshal(codec, v)
+	if err != nil {
+		return err
+	}
+	return service.Set(ctx, key, data, ttl)
+}
+
+// SetNX sets the value of a key only if it does not exist. It returns true
+// when the value was set.
+func (service *RedigoService) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	_, err := redis.String(service.Do(ctx, "SET", expirationArgs(redis.Args{key, value}, ttl).Add("NX")...))
+	if err == ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr increments the integer value of a key by one.
+func (service *RedigoService) Incr(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "INCR", key))
+}
+
+// IncrBy increments the integer value of a key by the given amount.
+func (service *RedigoService) IncrBy(ctx context.Context, key string, increment int64) (int64, error) {
+	return redis.Int64(service.Do(ctx, "INCRBY", key, increment))
+}
+
+// HGet returns the value of a hash field, or `ErrNil` if it does not exist.
+func (service *RedigoService) HGet(ctx context.Context, key, field string) (string, error) {
+	return redis.String(service.Do(ctx, "HGET", key, field))
+}
+
+// HSet sets the value of a hash field. It returns true when the field is new.
+func (service *RedigoService) HSet(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	return redis.Bool(service.Do(ctx, "HSET", key, field, value))
+}
+
+// HMSet sets multiple hash fields.
+func (service *RedigoService) HMSet(ctx context.Context, key string, fields map[string]interface{}) error {
+	_, err := service.Do(ctx, "HMSET", redis.Args{key}.AddFlat(fields)...)
+	return err
+}
+
+// HGetAll returns all fields and values of a hash.
+func (service *RedigoService) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return redis.StringMap(service.Do(ctx, "HGETALL", key))
+}
+
+// HDel removes hash fields returning how many were removed.
+func (service *RedigoService) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "HDEL", redis.Args{key}.AddFlat(fields)...))
+}
+
+// HExists checks if a hash field exists.
+func (service *RedigoService) HExists(ctx context.Context, key, field string) (bool, error) {
+	return redis.Bool(service.Do(ctx, "HEXISTS", key, field))
+}
+
+// HIncrBy increments the integer value of a hash field by the given amount.
+func (service *RedigoService) HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error) {
+	return redis.Int64(service.Do(ctx, "HINCRBY", key, field, increment))
+}
+
+// LPush prepends values to a list returning its new length.
+func (service *RedigoService) LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return redis.Int64(service.Do(ctx, "LPUSH", redis.Args{key}.Add(values...)...))
+}
+
+// RPush appends values to a list returning its new length.
+func (service *RedigoService) RPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return redis.Int64(service.Do(ctx, "RPUSH", redis.Args{key}.Add(values...)...))
+}
+
+// LPop removes and returns the first element of a list, or `ErrNil` if the
+// list is empty.
+func (service *RedigoService) LPop(ctx context.Context, key string) (string, error) {
+	return redis.String(service.Do(ctx, "LPOP", key))
+}
+
+// RPop removes and returns the last element of a list, or `ErrNil` if the
+// list is empty.
+func (service *RedigoService) RPop(ctx context.Context, key string) (string, error) {
+	return redis.String(service.Do(ctx, "RPOP", key))
+}
+
+// LRange returns the elements of a list between start and stop (inclusive).
+func (service *RedigoService) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return redis.Strings(service.Do(ctx, "LRANGE", key, start, stop))
+}
+
+// LLen returns the length of a list.
+func (service *RedigoService) LLen(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "LLEN", key))
+}
+
+// SAdd adds members to a set returning how many were added.
+func (service *RedigoService) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return redis.Int64(service.Do(ctx, "SADD", redis.Args{key}.Add(members...)...))
+}
+
+// SRem removes members from a set returning how many were removed.
+func (service *RedigoService) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return redis.Int64(service.Do(ctx, "SREM", redis.Args{key}.Add(members...)...))
+}
+
+// SMembers returns all members of a set.
+func (service *RedigoService) SMembers(ctx context.Context, key string) ([]string, error) {
+	return redis.Strings(service.Do(ctx, "SMEMBERS", key))
+}
+
+// SIsMember checks if the member belongs to a set.
+func (service *RedigoService) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return redis.Bool(service.Do(ctx, "SISMEMBER", key, member))
+}
+
+// SCard returns the number of members of a set.
+func (service *RedigoService) SCard(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "SCARD", key))
+}
+
+// ZAdd adds members to a sorted set returning how many were added.
+func (service *RedigoService) ZAdd(ctx context.Context, key string, members ...ZMember) (int64, error) {
+	args := redis.Args{key}
+	for _, member := range members {
+		args = args.Add(member.Score, member.Member)
+	}
+	return redis.Int64(service.Do(ctx, "ZADD", args...))
+}
+
+// ZRem removes members from a sorted set returning how many were removed.
+func (service *RedigoService) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return redis.Int64(service.Do(ctx, "ZREM", redis.Args{key}.Add(members...)...))
+}
+
+// ZScore returns the score of a member, or `ErrNil` if it does not exist.
+func (service *RedigoService) ZScore(ctx context.Context, key string, member interface{}) (float64, error) {
+	return redis.Float64(service.Do(ctx, "ZSCORE", key, member))
+}
+
+// ZIncrBy increments the score of a member returning the new score.
+func (service *RedigoService) ZIncrBy(ctx context.Context, key string, increment float64, member interface{}) (float64, error) {
+	return redis.Float64(service.Do(ctx, "ZINCRBY", key, increment, member))
+}
+
+// ZRange returns the members of a sorted set between start and stop
+// (inclusive) ordered by score.
+func (service *RedigoService) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return redis.Strings(service.Do(ctx, "ZRANGE", key, start, stop))
+}
+
+// ZRangeWithScores is the same as `ZRange` but returning the scores too.
+func (service *RedigoService) ZRangeWithScores(ctx context.Context, key string, start, stop int64) ([]ZMember, error) {
+	return zMembers(service.Do(ctx, "ZRANGE", key, start, stop, "WITHSCORES"))
+}
+
+// ZRangeByScore returns the members of a sorted set with scores between min
+// and max. Both accept the Redis syntax, as "-inf" and "(1".
+func (service *RedigoService) ZRangeByScore(ctx context.Context, key string, min, max interface{}) ([]ZMember, error) {
+	return zMembers(service.Do(ctx, "ZRANGEBYSCORE", key, min, max, "WITHSCORES"))
+}
+
+// ZCard returns the number of members of a sorted set.
+func (service *RedigoService) ZCard(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "ZCARD", key))
+}
+
+// zMembers converts a WITHSCORES reply into a slice of `ZMember`.
+func zMembers(reply interface{}, err error) ([]ZMember, error) {
+	values, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, len(values)/2)
+	for i := range members {
+		score, err := strconv.ParseFloat(values[i*2+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members[i] = ZMember{Member: values[i*2], Score: score}
+	}
+	return members, nil
+}
+
+// Del removes keys returning how many were removed.
+func (service *RedigoService) Del(ctx context.Context, keys ...string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "DEL", redis.Args{}.AddFlat(keys)...))
+}
+
+// Exists returns how many of the keys exist.
+func (service *RedigoService) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return redis.Int64(service.Do(ctx, "EXISTS", redis.Args{}.AddFlat(keys)...))
+}
+
+// Expire sets the expiration of a key. It returns false when the key does not
+// exist.
+func (service *RedigoService) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return redis.Bool(service.Do(ctx, "PEXPIRE", key, int64(ttl/time.Millisecond)))
+}
+
+// Persist removes the expiration of a key. It returns false when the key does
+// not exist or has no expiration.
+func (service *RedigoService) Persist(ctx context.Context, key string) (bool, error) {
+	return redis.Bool(service.Do(ctx, "PERSIST", key))
+}
+
+// TTL returns the remaining time to live of a key. It returns `ErrNil` when
+// the key does not exist and `NoExpiration` when it has no expiration.
+func (service *RedigoService) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := redis.Int64(service.Do(ctx, "PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2:
+		return 0, ErrNil
+	case -1:
+		return NoExpiration, nil
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
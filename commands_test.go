@@ -0,0 +1,128 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Commands)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Do(ctx, "DEL", "cmd-string", "cmd-hash", "cmd-list", "cmd-set", "cmd-zset")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should set and get strings", func() {
+		_, err := service.Get(ctx, "cmd-string")
+		Expect(err).To(Equal(ErrNil))
+
+		Expect(service.Set(ctx, "cmd-string", "value", 0)).To(Succeed())
+		Expect(service.Get(ctx, "cmd-string")).To(Equal("value"))
+		Expect(service.GetBytes(ctx, "cmd-string")).To(Equal([]byte("value")))
+
+		Expect(service.SetNX(ctx, "cmd-string", "other", 0)).To(BeFalse())
+		Expect(service.Del(ctx, "cmd-string")).To(Equal(int64(1)))
+		Expect(service.SetNX(ctx, "cmd-string", "1", 0)).To(BeTrue())
+		Expect(service.Incr(ctx, "cmd-string")).To(Equal(int64(2)))
+		Expect(service.IncrBy(ctx, "cmd-string", 3)).To(Equal(int64(5)))
+	})
+
+	It("should handle hashes", func() {
+		_, err := service.HGet(ctx, "cmd-hash", "field")
+		Expect(err).To(Equal(ErrNil))
+
+		Expect(service.HSet(ctx, "cmd-hash", "field", "value")).To(BeTrue())
+		Expect(service.HMSet(ctx, "cmd-hash", map[string]interface{}{
+			"counter": 1,
+		})).To(Succeed())
+		Expect(service.HGet(ctx, "cmd-hash", "field")).To(Equal("value"))
+		Expect(service.HIncrBy(ctx, "cmd-hash", "counter", 2)).To(Equal(int64(3)))
+		Expect(service.HGetAll(ctx, "cmd-hash")).To(Equal(map[string]string{
+			"field":   "value",
+			"counter": "3",
+		}))
+		Expect(service.HDel(ctx, "cmd-hash", "field")).To(Equal(int64(1)))
+		Expect(service.HExists(ctx, "cmd-hash", "field")).To(BeFalse())
+	})
+
+	It("should handle lists", func() {
+		Expect(service.RPush(ctx, "cmd-list", "b", "c")).To(Equal(int64(2)))
+		Expect(service.LPush(ctx, "cmd-list", "a")).To(Equal(int64(3)))
+		Expect(service.LRange(ctx, "cmd-list", 0, -1)).To(Equal([]string{"a", "b", "c"}))
+		Expect(service.LLen(ctx, "cmd-list")).To(Equal(int64(3)))
+		Expect(service.LPop(ctx, "cmd-list")).To(Equal("a"))
+		Expect(service.RPop(ctx, "cmd-list")).To(Equal("c"))
+		Expect(service.RPop(ctx, "cmd-list")).To(Equal("b"))
+		_, err := service.RPop(ctx, "cmd-list")
+		Expect(err).To(Equal(ErrNil))
+	})
+
+	It("should handle sets", func() {
+		Expect(service.SAdd(ctx, "cmd-set", "a", "b", "a")).To(Equal(int64(2)))
+		Expect(service.SIsMember(ctx, "cmd-set", "a")).To(BeTrue())
+		Expect(service.SCard(ctx, "cmd-set")).To(Equal(int64(2)))
+		Expect(service.SRem(ctx, "cmd-set", "a")).To(Equal(int64(1)))
+		Expect(service.SMembers(ctx, "cmd-set")).To(Equal([]string{"b"}))
+	})
+
+	It("should handle sorted sets", func() {
+		Expect(service.ZAdd(ctx, "cmd-zset", ZMember{"a", 1}, ZMember{"b", 2})).To(Equal(int64(2)))
+		Expect(service.ZIncrBy(ctx, "cmd-zset", 2, "a")).To(Equal(float64(3)))
+		Expect(service.ZScore(ctx, "cmd-zset", "a")).To(Equal(float64(3)))
+		_, err := service.ZScore(ctx, "cmd-zset", "c")
+		Expect(err).To(Equal(ErrNil))
+		Expect(service.ZRange(ctx, "cmd-zset", 0, -1)).To(Equal([]string{"b", "a"}))
+		Expect(service.ZRangeWithScores(ctx, "cmd-zset", 0, 0)).To(Equal([]ZMember{{"b", 2}}))
+		Expect(service.ZRangeByScore(ctx, "cmd-zset", "(2", "+inf")).To(Equal([]ZMember{{"a", 3}}))
+		Expect(service.ZRem(ctx, "cmd-zset", "a")).To(Equal(int64(1)))
+		Expect(service.ZCard(ctx, "cmd-zset")).To(Equal(int64(1)))
+	})
+
+	It("should handle keys expiration", func() {
+		_, err := service.TTL(ctx, "cmd-string")
+		Expect(err).To(Equal(ErrNil))
+
+		Expect(service.Set(ctx, "cmd-string", "value", 0)).To(Succeed())
+		Expect(service.TTL(ctx, "cmd-string")).To(Equal(NoExpiration))
+		Expect(service.Expire(ctx, "cmd-string", time.Minute)).To(BeTrue())
+		Expect(service.TTL(ctx, "cmd-string")).To(BeNumerically("~", time.Minute, time.Second))
+		Expect(service.Persist(ctx, "cmd-string")).To(BeTrue())
+		Expect(service.Exists(ctx, "cmd-string", "cmd-hash")).To(Equal(int64(1)))
+	})
+
+	It("should fail when the context is done", func() {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := service.Get(ctx, "cmd-string")
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("should record the metrics of the commands", func() {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		_, err := service.Get(ctx, "cmd-string")
+		Expect(err).To(Equal(ErrNil))
+
+		var metric dto.Metric
+		Expect(service.Collector.commandCalls.With(prometheus.Labels{
+			"method":  "DoWithTimeout",
+			"command": "GET",
+		}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(1.0))
+	})
+})
@@ -0,0 +1,164 @@
+package redigosrv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnknownCompression is returned when no compression algorithm exists with
+// the given name or header.
+var ErrUnknownCompression = errors.New("unknown compression algorithm")
+
+// CompressionConfiguration is the configuration of the compression of the
+// values written by the service.
+//
+// The values starting with the envelope marker, 0xc1, followed by a byte from
+// 0x40 to 0x5f are read as compressed, even if the algorithm is empty, so the
+// values compressed before still read. When the algorithm is empty, the ones
+// that do not decompress are read as they are.
+type CompressionConfiguration struct {
+	// Algorithm is "gzip", "snappy" or "zstd". If empty, values are not
+	// compressed.
+	Algorithm string `yaml:"algorithm"`
+	// Threshold is the minimum size, in bytes, of the values compressed.
+	// Defaults to 1024.
+	Threshold int `yaml:"threshold"`
+}
+
+// compressor compresses the values using an algorithm. Compressed values are
+// prefixed with the envelope marker and the tag of the algorithm, from 0x40 to
+// 0x5f so it does not clash with the content types nor the encryption.
+type compressor struct {
+	tag        byte
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte) ([]byte, error)
+}
+
+var zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+// zstdInit creates the zstd encoder and decoder, which are safe for
+// concurrent use.
+func zstdInit() error {
+	zstdCodec.once.Do(func() {
+		zstdCodec.encoder, zstdCodec.err = zstd.NewWriter(nil)
+		if zstdCodec.err == nil {
+			zstdCodec.decoder, zstdCodec.err = zstd.NewReader(nil)
+		}
+	})
+	return zstdCodec.err
+}
+
+var compressors = map[string]*compressor{
+	"gzip": {
+		tag: 0x41,
+		compress: func(data []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		},
+	},
+	"snappy": {
+		tag: 0x42,
+		compress: func(data []byte) ([]byte, error) {
+			return snappy.Encode(nil, data), nil
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			return snappy.Decode(nil, data)
+		},
+	},
+	"zstd": {
+		tag: 0x43,
+		compress: func(data []byte) ([]byte, error) {
+			if err := zstdInit(); err != nil {
+				return nil, err
+			}
+			return zstdCodec.encoder.EncodeAll(data, nil), nil
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			if err := zstdInit(); err != nil {
+				return nil, err
+			}
+			return zstdCodec.decoder.DecodeAll(data, nil)
+		},
+	},
+}
+
+func compressorByTag(tag byte) (*compressor, bool) {
+	for _, c := range compressors {
+		if c.tag == tag {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// compress compresses the data when the compression is enabled, the data is
+// above the threshold and compressing it saves space.
+func (service *RedigoService) compress(data []byte) ([]byte, error) {
+	config := service.Configuration.Compression
+	c, ok := compressors[config.Algorithm]
+	if !ok || len(data) < config.Threshold {
+		return data, nil
+	}
+
+	compressed, err := c.compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+2 >= len(data) {
+		return data, nil
+	}
+
+	// Increment with the sizes before and after compressing
+	service.Collector.compressionRawBytes.Add(float64(len(data)))
+	service.Collector.compressionWireBytes.Add(float64(len(compressed) + 2))
+
+	return append([]byte{envelopeMarker, c.tag}, compressed...), nil
+}
+
+// decompress decompresses the data if it has a compression header, whatever
+// the configured algorithm is. When the compression is disabled, the data
+// that does not decompress is returned as it is, as it was never compressed.
+func (service *RedigoService) decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != envelopeMarker || data[1]&0xe0 != 0x40 {
+		return data, nil
+	}
+	disabled := service.Configuration.Compression.Algorithm == ""
+	c, ok := compressorByTag(data[1])
+	if !ok {
+		if disabled {
+			return data, nil
+		}
+		return nil, ErrUnknownCompression
+	}
+	decompressed, err := c.decompress(data[2:])
+	if err != nil && disabled {
+		return data, nil
+	}
+	return decompressed, err
+}
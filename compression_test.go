@@ -0,0 +1,119 @@
+package redigosrv
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Compression", func() {
+	var service RedigoService
+	ctx := context.Background()
+	payload := strings.Repeat("compressible ", 200)
+
+	start := func(algorithm string) {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Compression: CompressionConfiguration{
+				Algorithm: algorithm,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+	}
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should fail with an unknown algorithm", func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Compression: CompressionConfiguration{
+				Algorithm: "lzma",
+			},
+		})).To(Equal(ErrUnknownCompression))
+	})
+
+	for _, algorithm := range []string{"gzip", "snappy", "zstd"} {
+		algorithm := algorithm
+
+		It("should compress values above the threshold using "+algorithm, func() {
+			start(algorithm)
+
+			Expect(service.SetValue(ctx, "compressed", payload, 0, nil)).To(Succeed())
+			data, err := service.GetBytes(ctx, "compressed")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data[0]).To(Equal(byte(envelopeMarker)))
+			Expect(len(data)).To(BeNumerically("<", len(payload)))
+
+			var value string
+			Expect(service.GetValue(ctx, "compressed", &value, nil)).To(Succeed())
+			Expect(value).To(Equal(payload))
+
+			var raw, wire dto.Metric
+			Expect(service.Collector.compressionRawBytes.Write(&raw)).To(Succeed())
+			Expect(service.Collector.compressionWireBytes.Write(&wire)).To(Succeed())
+			Expect(raw.GetCounter().GetValue()).To(Equal(float64(len(payload) + 2)))
+			Expect(wire.GetCounter().GetValue()).To(Equal(float64(len(data))))
+		})
+	}
+
+	It("should not compress values below the threshold", func() {
+		start("gzip")
+
+		Expect(service.SetValue(ctx, "compressed", "small", 0, nil)).To(Succeed())
+		Expect(service.Get(ctx, "compressed")).To(Equal(`"small"`))
+	})
+
+	It("should decompress values whatever the configured algorithm is", func() {
+		start("zstd")
+		Expect(service.SetValue(ctx, "compressed", payload, 0, nil)).To(Succeed())
+		service.Stop()
+
+		start("")
+		var value string
+		Expect(service.GetValue(ctx, "compressed", &value, nil)).To(Succeed())
+		Expect(value).To(Equal(payload))
+	})
+
+	It("should read values with a compression prefix as they are when disabled", func() {
+		data := []byte{envelopeMarker, 0x41, 'n', 'o', 't'}
+
+		start("")
+		Expect(service.SetValue(ctx, "compressed", data, 0, rawTestCodec{})).To(Succeed())
+		var value []byte
+		Expect(service.GetValue(ctx, "compressed", &value, rawTestCodec{})).To(Succeed())
+		Expect(value).To(Equal(data))
+		service.Stop()
+
+		start("gzip")
+		Expect(service.GetValue(ctx, "compressed", &value, rawTestCodec{})).ToNot(Succeed())
+	})
+
+	It("should publish compressed messages", func(done Done) {
+		start("snappy")
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(service.Publish(ctx, "test-compressed", []byte(payload))).To(Succeed())
+			return nil
+		}
+
+		Expect(service.Subscribe(ctx, onSubscribed, func(channel string, data []byte) error {
+			Expect(string(data)).To(Equal(payload))
+			cancel()
+			return nil
+		}, "test-compressed")).To(Succeed())
+
+		var traffic, wire dto.Metric
+		Expect(service.Collector.publishTrafficSize.Write(&traffic)).To(Succeed())
+		Expect(service.Collector.compressionWireBytes.Write(&wire)).To(Succeed())
+		Expect(traffic.GetCounter().GetValue()).To(Equal(wire.GetCounter().GetValue()))
+		Expect(traffic.GetCounter().GetValue()).To(BeNumerically("<", len(payload)))
+
+		close(done)
+	})
+})
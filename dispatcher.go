@@ -0,0 +1,205 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ErrDispatcherClosed is returned when handling messages with a closed
+// `Dispatcher`.
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+// DispatcherOptions are the options of a `Dispatcher`.
+type DispatcherOptions struct {
+	// Workers is the number of messages handled in parallel. Defaults to 10.
+	Workers int
+	// QueueSize is the number of messages waiting for each worker before
+	// `Handle` blocks. Defaults to 100.
+	QueueSize int
+	// Key returns the ordering key of a message. Messages with the same key
+	// are handled in the order they were received. Defaults to the channel.
+	Key func(channel string, data []byte) string
+}
+
+// Dispatcher handles the messages of a subscription with a pool of workers,
+// so a slow message does not stop the connection from receiving. Its
+// `Handle` method is used as the `SubscriptionHandler`:
+//
+//	dispatcher := service.NewDispatcher(handler, DispatcherOptions{})
+//	err := service.Subscribe(ctx, subscribed, dispatcher.Handle, channels...)
+//	dispatcher.Close()
+//
+// Each key is always handled by the same worker, which keeps the order of
+// its messages while different keys are handled in parallel. The workers
+// apply the handler error policy (see `HandlerErrorConfiguration`) to the
+// failed messages. Once a failure stops the dispatcher, `Handle` returns its
+// error, which ends the subscription.
+//
+// `Handle` returns as soon as the message is queued, so in durable mode (see
+// `DurablePubSubConfiguration`) the entry is acknowledged before it is
+// handled.
+// Messages queued when the process stops, or failed in a worker, are not
+// delivered again: the delivery is at most once. Durable subscriptions that
+// need every message handled should not use a `Dispatcher`.
+type Dispatcher struct {
+	service *RedigoService
+	handler SubscriptionHandler
+	opts    DispatcherOptions
+	queues  []chan dispatchedMessage
+
+	// mu guards closed, so no sender starts once the dispatcher is closed.
+	// The queues are closed when the senders are done.
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{}
+	senders sync.WaitGroup
+
+	errMu sync.Mutex
+	err   error
+
+	wg sync.WaitGroup
+}
+
+type dispatchedMessage struct {
+	channel string
+	data    []byte
+}
+
+// dispatcherError is the error that stopped a `Dispatcher`, returned by
+// `Handle` so the subscription does not apply the error policy again.
+type dispatcherError struct {
+	err error
+}
+
+func (err *dispatcherError) Error() string {
+	return err.err.Error()
+}
+
+// NewDispatcher returns a new `Dispatcher` calling the handler, that must be
+// closed with `Close`.
+func (service *RedigoService) NewDispatcher(handler SubscriptionHandler, opts DispatcherOptions) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 10
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.Key == nil {
+		opts.Key = func(channel string, data []byte) string {
+			return channel
+		}
+	}
+	dispatcher := &Dispatcher{
+		service: service,
+		handler: handler,
+		opts:    opts,
+		queues:  make([]chan dispatchedMessage, opts.Workers),
+		done:    make(chan struct{}),
+	}
+	for i := range dispatcher.queues {
+		dispatcher.queues[i] = make(chan dispatchedMessage, opts.QueueSize)
+		dispatcher.wg.Add(1)
+		go dispatcher.work(dispatcher.queues[i])
+	}
+	return dispatcher
+}
+
+// Handle queues the message to the worker of its key, blocking while the
+// queue is full or until the dispatcher is closed. It returns the error that
+// stopped the dispatcher, if any.
+func (dispatcher *Dispatcher) Handle(channel string, data []byte) error {
+	if err := dispatcher.Err(); err != nil {
+		return &dispatcherError{err: err}
+	}
+
+	dispatcher.mu.Lock()
+	if dispatcher.closed {
+		dispatcher.mu.Unlock()
+		return ErrDispatcherClosed
+	}
+	dispatcher.senders.Add(1)
+	dispatcher.mu.Unlock()
+	defer dispatcher.senders.Done()
+
+	h := fnv.New32a()
+	h.Write([]byte(dispatcher.opts.Key(channel, data)))
+	queue := dispatcher.queues[h.Sum32()%uint32(len(dispatcher.queues))]
+
+	// Increment 1 in dispatchQueueDepth
+	dispatcher.service.Collector.dispatchQueueDepth.Inc()
+
+	select {
+	case queue <- dispatchedMessage{channel: channel, data: data}:
+		return nil
+	case <-dispatcher.done:
+		// Decrement 1 in dispatchQueueDepth
+		dispatcher.service.Collector.dispatchQueueDepth.Dec()
+		return ErrDispatcherClosed
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be
+// handled, returning the error that stopped the dispatcher, if any.
+func (dispatcher *Dispatcher) Close() error {
+	dispatcher.mu.Lock()
+	if dispatcher.closed {
+		dispatcher.mu.Unlock()
+		dispatcher.wg.Wait()
+		return dispatcher.Err()
+	}
+	dispatcher.closed = true
+	close(dispatcher.done)
+	dispatcher.mu.Unlock()
+
+	// The blocked senders give up, so the queues can be closed.
+	dispatcher.senders.Wait()
+	for _, queue := range dispatcher.queues {
+		close(queue)
+	}
+
+	dispatcher.wg.Wait()
+	return dispatcher.Err()
+}
+
+// Err returns the error that stopped the dispatcher, if any.
+func (dispatcher *Dispatcher) Err() error {
+	dispatcher.errMu.Lock()
+	defer dispatcher.errMu.Unlock()
+	return dispatcher.err
+}
+
+func (dispatcher *Dispatcher) work(queue chan dispatchedMessage) {
+	defer dispatcher.wg.Done()
+
+	collector := dispatcher.service.Collector
+	for msg := range queue {
+		// Decrement 1 in dispatchQueueDepth
+		collector.dispatchQueueDepth.Dec()
+
+		start := time.Now()
+		handler := func() error {
+			return dispatcher.handler(msg.channel, msg.data)
+		}
+		err := handler()
+		if err != nil {
+			err = dispatcher.service.handleError(context.Background(), msg.channel, msg.data, err, handler)
+		}
+		collector.dispatchDuration.Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			dispatcher.fail(err)
+		}
+	}
+}
+
+// fail keeps the first error that stopped the dispatcher.
+func (dispatcher *Dispatcher) fail(err error) {
+	dispatcher.errMu.Lock()
+	defer dispatcher.errMu.Unlock()
+	if dispatcher.err == nil {
+		dispatcher.err = err
+	}
+}
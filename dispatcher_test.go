@@ -0,0 +1,268 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Dispatcher", func() {
+	var service RedigoService
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should keep the order of each channel", func() {
+		var mu sync.Mutex
+		received := map[string][]int{}
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			var i int
+			fmt.Sscan(string(data), &i)
+			mu.Lock()
+			received[channel] = append(received[channel], i)
+			mu.Unlock()
+			return nil
+		}, DispatcherOptions{Workers: 4})
+
+		for i := 0; i < 30; i++ {
+			Expect(dispatcher.Handle(fmt.Sprintf("ch-%d", i%3), []byte(fmt.Sprint(i)))).To(Succeed())
+		}
+		Expect(dispatcher.Close()).To(Succeed())
+
+		Expect(received).To(Equal(map[string][]int{
+			"ch-0": {0, 3, 6, 9, 12, 15, 18, 21, 24, 27},
+			"ch-1": {1, 4, 7, 10, 13, 16, 19, 22, 25, 28},
+			"ch-2": {2, 5, 8, 11, 14, 17, 20, 23, 26, 29},
+		}))
+
+		var metric dto.Metric
+		Expect(service.Collector.dispatchDuration.Write(&metric)).To(Succeed())
+		Expect(metric.GetHistogram().GetSampleCount()).To(Equal(uint64(30)))
+		Expect(service.Collector.dispatchQueueDepth.Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(0)))
+	})
+
+	It("should handle the keys in parallel", func() {
+		release := make(chan struct{})
+		fast := make(chan string, 10)
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			if string(data) == "slow" {
+				<-release
+				return nil
+			}
+			fast <- string(data)
+			return nil
+		}, DispatcherOptions{
+			Workers: 2,
+			Key: func(channel string, data []byte) string {
+				return string(data)
+			},
+		})
+
+		Expect(dispatcher.Handle("ch", []byte("slow"))).To(Succeed())
+		Expect(dispatcher.Handle("ch", []byte("fast"))).To(Succeed())
+		Eventually(fast).Should(Receive(Equal("fast")))
+
+		close(release)
+		Expect(dispatcher.Close()).To(Succeed())
+	})
+
+	It("should return the error of the handler", func() {
+		failure := errors.New("something bad")
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			return failure
+		}, DispatcherOptions{})
+
+		Expect(dispatcher.Handle("ch", []byte("failing"))).To(Succeed())
+		Eventually(func() error {
+			return dispatcher.Handle("ch", []byte("other"))
+		}).Should(MatchError(failure.Error()))
+		Expect(dispatcher.Close()).To(Equal(failure))
+	})
+
+	It("should log the errors of the handler and continue", func() {
+		service.Configuration.PubSub.HandlerError = HandlerErrorConfiguration{
+			Policy:  ErrorPolicyLog,
+			Retries: 2,
+			Backoff: time.Millisecond,
+		}
+
+		var mu sync.Mutex
+		var received []string
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, string(data))
+			if string(data) == "failing" {
+				return errors.New("something bad")
+			}
+			return nil
+		}, DispatcherOptions{Workers: 1})
+
+		Expect(dispatcher.Handle("ch", []byte("failing"))).To(Succeed())
+		Expect(dispatcher.Close()).To(Succeed())
+		Expect(dispatcher.Handle("ch", []byte("closed"))).To(Equal(ErrDispatcherClosed))
+
+		dispatcher = service.NewDispatcher(func(channel string, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, string(data))
+			return errors.New("something bad")
+		}, DispatcherOptions{Workers: 1})
+		Expect(dispatcher.Handle("ch", []byte("first"))).To(Succeed())
+		Expect(dispatcher.Handle("ch", []byte("second"))).To(Succeed())
+		Expect(dispatcher.Close()).To(Succeed())
+
+		// The retries are not used by the log policy.
+		Expect(received).To(Equal([]string{"failing", "first", "second"}))
+		var metric dto.Metric
+		Expect(service.Collector.handlerErrors.With(prometheus.Labels{"action": "log"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(3)))
+	})
+
+	It("should send the failed messages to the dead-letter stream", func() {
+		service.Configuration.PubSub.HandlerError = HandlerErrorConfiguration{
+			Policy:           ErrorPolicyDeadLetter,
+			Retries:          1,
+			Backoff:          time.Millisecond,
+			DeadLetter:       "test-dispatch-dead",
+			DeadLetterStream: true,
+		}
+		ctx := context.Background()
+		_, err := service.Do(ctx, "DEL", "test-dispatch-dead")
+		Expect(err).ToNot(HaveOccurred())
+
+		var mu sync.Mutex
+		calls := 0
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if string(data) == "failing" {
+				return errors.New("something bad")
+			}
+			return nil
+		}, DispatcherOptions{Workers: 1})
+
+		Expect(dispatcher.Handle("ch", []byte("failing"))).To(Succeed())
+		Expect(dispatcher.Handle("ch", []byte("other"))).To(Succeed())
+		Expect(dispatcher.Close()).To(Succeed())
+		Expect(calls).To(Equal(3))
+
+		reply, err := service.Do(ctx, "XRANGE", "test-dispatch-dead", "-", "+")
+		Expect(err).ToNot(HaveOccurred())
+		entries, err := parseStreamEntries(reply)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		var letter DeadLetter
+		Expect(service.Unmarshal(nil, entries[0].data, &letter)).To(Succeed())
+		Expect(letter.Channel).To(Equal("ch"))
+		Expect(letter.Data).To(Equal([]byte("failing")))
+	})
+
+	It("should not apply the error policy twice in a subscription", func(done Done) {
+		service.Configuration.PubSub.HandlerError = HandlerErrorConfiguration{
+			Policy:  ErrorPolicyRetry,
+			Retries: 1,
+			Backoff: time.Millisecond,
+		}
+		ctx := context.Background()
+		failure := errors.New("something bad")
+
+		calls := make(chan struct{}, 10)
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			calls <- struct{}{}
+			return failure
+		}, DispatcherOptions{})
+
+		Expect(service.Subscribe(ctx, func() error {
+			go func() {
+				defer GinkgoRecover()
+
+				// Once stopped, the next message ends the subscription.
+				Eventually(dispatcher.Err).Should(Equal(failure))
+				Expect(service.Publish(ctx, "test-dispatch", []byte("stopped"))).To(Succeed())
+			}()
+			return service.Publish(ctx, "test-dispatch", []byte("failing"))
+		}, dispatcher.Handle, "test-dispatch")).To(Equal(failure))
+		Expect(dispatcher.Close()).To(Equal(failure))
+		Expect(calls).To(HaveLen(2))
+
+		var metric dto.Metric
+		Expect(service.Collector.handlerErrors.With(prometheus.Labels{"action": "retry"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+		Expect(service.Collector.handlerErrors.With(prometheus.Labels{"action": "stop"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+
+		close(done)
+	})
+
+	It("should not handle messages when closed", func() {
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			return nil
+		}, DispatcherOptions{})
+		Expect(dispatcher.Close()).To(Succeed())
+		Expect(dispatcher.Handle("ch", nil)).To(Equal(ErrDispatcherClosed))
+	})
+
+	It("should close while a message waits for a full queue", func(done Done) {
+		release := make(chan struct{})
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			<-release
+			return nil
+		}, DispatcherOptions{Workers: 1, QueueSize: 1})
+
+		// The first message is handled, the second is queued and the third
+		// waits for the queue.
+		Expect(dispatcher.Handle("ch", []byte("1"))).To(Succeed())
+		Expect(dispatcher.Handle("ch", []byte("2"))).To(Succeed())
+		blocked := make(chan error, 1)
+		go func() {
+			blocked <- dispatcher.Handle("ch", []byte("3"))
+		}()
+		Consistently(blocked, "20ms").ShouldNot(Receive())
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- dispatcher.Close()
+		}()
+		Eventually(blocked).Should(Receive(Equal(ErrDispatcherClosed)))
+		close(release)
+		Eventually(closed).Should(Receive(BeNil()))
+
+		close(done)
+	})
+
+	It("should handle the messages of a subscription", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		dispatcher := service.NewDispatcher(func(channel string, data []byte) error {
+			Expect(data).To(Equal([]byte("dispatched")))
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			return nil
+		}, DispatcherOptions{})
+
+		Expect(service.Subscribe(ctx, func() error {
+			return service.Publish(ctx, "test-dispatch", []byte("dispatched"))
+		}, dispatcher.Handle, "test-dispatch")).To(Succeed())
+		Expect(dispatcher.Close()).To(Succeed())
+
+		close(done)
+	})
+})
@@ -0,0 +1,237 @@
+package redigosrv
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrUnknownEncryptionKey is returned when a value was encrypted with a key
+// that is not configured.
+var ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+
+// ErrInvalidEncryptionKey is returned when a configured key is not a base64
+// encoded AES key (16, 24 or 32 bytes) or its ID is invalid.
+var ErrInvalidEncryptionKey = errors.New("invalid encryption key")
+
+// ErrNotEncrypted is returned, in strict mode, when reading a value that is
+// not encrypted.
+var ErrNotEncrypted = errors.New("value not encrypted")
+
+// ErrEncryptionDisabled is returned by `ReEncrypt` when no keys are
+// configured.
+var ErrEncryptionDisabled = errors.New("encryption disabled")
+
+// ErrInvalidCiphertext is returned when an encrypted value is malformed or
+// was tampered with.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// encryptionTag follows the envelope marker in encrypted values. It is then
+// followed by the length of the key ID, the key ID, the nonce and the
+// ciphertext.
+const encryptionTag = 0x60
+
+const (
+	reEncryptScriptName = "redigosrv:encryption:reencrypt"
+	reEncryptScript     = `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("SET", KEYS[1], ARGV[2])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`
+)
+
+// EncryptionKey is an AES key identified by an ID, which is embedded in the
+// values it encrypts.
+type EncryptionKey struct {
+	ID string `yaml:"id"`
+	// Key is the base64 encoded AES key of 16, 24 or 32 bytes.
+	Key string `yaml:"key"`
+}
+
+// EncryptionConfiguration is the configuration of the encryption, using
+// AES-GCM, of the values serialized by the service: the ones written by
+// `SetValue`, `Publish`, `StreamAdd` and the caches. The commands taking the
+// values as they are, such as `Set`, `HSet` or `LPush`, do not encrypt them.
+type EncryptionConfiguration struct {
+	// Keys are the keys that can decrypt values. When rotating, the old keys
+	// are kept here until the values are re-encrypted. If empty, values are
+	// neither encrypted nor decrypted.
+	Keys []EncryptionKey `yaml:"keys"`
+	// ActiveKey is the ID of the key used to encrypt values. Defaults to the
+	// first key.
+	ActiveKey string `yaml:"active_key"`
+	// Strict rejects the values read that are not encrypted. Otherwise they
+	// are read as they are, so encryption can be enabled on existing data,
+	// but anyone able to write to Redis can bypass it with plaintext values.
+	Strict bool `yaml:"strict"`
+}
+
+// keyring holds the ciphers of the configured keys.
+type keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+func newKeyring(config EncryptionConfiguration) (*keyring, error) {
+	if len(config.Keys) == 0 {
+		return nil, nil
+	}
+	ring := &keyring{
+		active: config.ActiveKey,
+		aeads:  make(map[string]cipher.AEAD, len(config.Keys)),
+	}
+	if ring.active == "" {
+		ring.active = config.Keys[0].ID
+	}
+	for _, key := range config.Keys {
+		if key.ID == "" || len(key.ID) > 255 {
+			return nil, ErrInvalidEncryptionKey
+		}
+		secret, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ring.aeads[key.ID] = aead
+	}
+	if _, ok := ring.aeads[ring.active]; !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	return ring, nil
+}
+
+// encrypt encrypts the data with the active key. The header, with the key
+// ID, is authenticated along with the data.
+func (ring *keyring) encrypt(data []byte) ([]byte, error) {
+	aead := ring.aeads[ring.active]
+	header := append([]byte{envelopeMarker, encryptionTag, byte(len(ring.active))}, ring.active...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, data, header), nil
+}
+
+// keyID returns the ID of the key that encrypted the data, if encrypted.
+func keyID(data []byte) (string, bool) {
+	if len(data) < 3 || data[0] != envelopeMarker || data[1] != encryptionTag {
+		return "", false
+	}
+	if len(data) < 3+int(data[2]) {
+		return "", false
+	}
+	return string(data[3 : 3+int(data[2])]), true
+}
+
+func (ring *keyring) decrypt(data []byte) ([]byte, error) {
+	id, _ := keyID(data)
+	var aead cipher.AEAD
+	if ring != nil {
+		aead = ring.aeads[id]
+	}
+	if aead == nil {
+		return nil, ErrUnknownEncryptionKey
+	}
+
+	header := data[:3+len(id)]
+	data = data[len(header):]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plain, nil
+}
+
+// encrypt encrypts the data when enabled.
+func (service *RedigoService) encrypt(data []byte) ([]byte, error) {
+	if service.keyring == nil {
+		return data, nil
+	}
+	return service.keyring.encrypt(data)
+}
+
+// decrypt decrypts the data if it is encrypted. Values not encrypted are
+// returned as they are, unless the encryption is strict. When the encryption
+// is disabled, all the values are returned as they are.
+func (service *RedigoService) decrypt(data []byte) ([]byte, error) {
+	if service.keyring == nil {
+		return data, nil
+	}
+	if _, ok := keyID(data); !ok {
+		if service.Configuration.Encryption.Strict {
+			return nil, ErrNotEncrypted
+		}
+		return data, nil
+	}
+	return service.keyring.decrypt(data)
+}
+
+// ReEncrypt walks the keys matching the pattern using SCAN and re-encrypts,
+// with the active key, the values encrypted with other keys. It returns how
+// many values were re-encrypted. Values changed while being re-encrypted are
+// skipped, and their TTLs are kept.
+func (service *RedigoService) ReEncrypt(ctx context.Context, pattern string) (int, error) {
+	if service.keyring == nil {
+		return 0, ErrEncryptionDisabled
+	}
+	if err := service.RegisterScript(reEncryptScriptName, 1, reEncryptScript); err != nil {
+		return 0, err
+	}
+
+	reEncrypted := 0
+	it := service.Scan(ctx, ScanOptions{Match: pattern, Count: 100, Type: "string"})
+	defer it.Close()
+	for it.Next() {
+		key := it.Val()
+		data, err := service.GetBytes(ctx, key)
+		if err == ErrNil {
+			continue
+		}
+		if err != nil {
+			return reEncrypted, err
+		}
+		id, ok := keyID(data)
+		if !ok || id == service.keyring.active {
+			continue
+		}
+
+		plain, err := service.keyring.decrypt(data)
+		if err != nil {
+			return reEncrypted, err
+		}
+		encrypted, err := service.keyring.encrypt(plain)
+		if err != nil {
+			return reEncrypted, err
+		}
+		n, err := redis.Int(service.EvalScript(ctx, reEncryptScriptName, key, data, encrypted))
+		if err != nil {
+			return reEncrypted, err
+		}
+		reEncrypted += n
+	}
+	return reEncrypted, it.Err()
+}
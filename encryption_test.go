@@ -0,0 +1,196 @@
+package redigosrv
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	key1 := EncryptionKey{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))}
+	key2 := EncryptionKey{ID: "k2", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", 16)))}
+
+	keyIDOf := func(data []byte) string {
+		id, ok := keyID(data)
+		Expect(ok).To(BeTrue())
+		return id
+	}
+
+	start := func(encryption EncryptionConfiguration) {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address:    "localhost:6379",
+			Encryption: encryption,
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		start(EncryptionConfiguration{})
+		_, err := service.Del(ctx, "pii:1", "pii:2", "pii:plain")
+		Expect(err).ToNot(HaveOccurred())
+		service.Stop()
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should fail with invalid keys", func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Encryption: EncryptionConfiguration{
+				Keys: []EncryptionKey{{ID: "k", Key: base64.StdEncoding.EncodeToString([]byte("short"))}},
+			},
+		})).To(Equal(ErrInvalidEncryptionKey))
+		Expect(service.ApplyConfiguration(Configuration{
+			Encryption: EncryptionConfiguration{
+				Keys:      []EncryptionKey{key1},
+				ActiveKey: "k2",
+			},
+		})).To(Equal(ErrUnknownEncryptionKey))
+	})
+
+	It("should encrypt values with the active key", func() {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+
+		Expect(service.SetValue(ctx, "pii:1", "secret", 0, nil)).To(Succeed())
+		data, err := service.GetBytes(ctx, "pii:1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).ToNot(ContainSubstring("secret"))
+		Expect(keyIDOf(data)).To(Equal("k1"))
+
+		var value string
+		Expect(service.GetValue(ctx, "pii:1", &value, nil)).To(Succeed())
+		Expect(value).To(Equal("secret"))
+	})
+
+	It("should reject tampered values", func() {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+
+		Expect(service.SetValue(ctx, "pii:1", "secret", 0, nil)).To(Succeed())
+		data, err := service.GetBytes(ctx, "pii:1")
+		Expect(err).ToNot(HaveOccurred())
+		data[len(data)-1] ^= 1
+		Expect(service.Set(ctx, "pii:1", data, 0)).To(Succeed())
+
+		var value string
+		Expect(service.GetValue(ctx, "pii:1", &value, nil)).To(Equal(ErrInvalidCiphertext))
+	})
+
+	It("should reject values not encrypted when strict", func() {
+		start(EncryptionConfiguration{})
+		Expect(service.SetValue(ctx, "pii:plain", "plain", 0, nil)).To(Succeed())
+		service.Stop()
+
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+		var value string
+		Expect(service.GetValue(ctx, "pii:plain", &value, nil)).To(Succeed())
+		Expect(value).To(Equal("plain"))
+		service.Stop()
+
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}, Strict: true})
+		Expect(service.GetValue(ctx, "pii:plain", &value, nil)).To(Equal(ErrNotEncrypted))
+	})
+
+	It("should fail decrypting values without the key", func() {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+		Expect(service.SetValue(ctx, "pii:1", "secret", 0, nil)).To(Succeed())
+		service.Stop()
+
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key2}})
+		var value string
+		Expect(service.GetValue(ctx, "pii:1", &value, nil)).To(Equal(ErrUnknownEncryptionKey))
+	})
+
+	It("should read values as they are when disabled", func() {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+		Expect(service.SetValue(ctx, "pii:1", "secret", 0, nil)).To(Succeed())
+		data, err := service.GetBytes(ctx, "pii:1")
+		Expect(err).ToNot(HaveOccurred())
+		service.Stop()
+
+		start(EncryptionConfiguration{})
+		var value []byte
+		Expect(service.GetValue(ctx, "pii:1", &value, rawTestCodec{})).To(Succeed())
+		Expect(value).To(Equal(data))
+	})
+
+	It("should rotate keys re-encrypting the values", func() {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+		Expect(service.SetValue(ctx, "pii:1", "secret 1", time.Minute, nil)).To(Succeed())
+		Expect(service.SetValue(ctx, "pii:2", "secret 2", 0, nil)).To(Succeed())
+		Expect(service.Set(ctx, "pii:plain", "plain", 0)).To(Succeed())
+		service.Stop()
+
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1, key2}, ActiveKey: "k2"})
+
+		// Both keys can decrypt while rotating.
+		var value string
+		Expect(service.GetValue(ctx, "pii:1", &value, nil)).To(Succeed())
+		Expect(value).To(Equal("secret 1"))
+
+		Expect(service.ReEncrypt(ctx, "pii:*")).To(Equal(2))
+		for _, key := range []string{"pii:1", "pii:2"} {
+			data, err := service.GetBytes(ctx, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keyIDOf(data)).To(Equal("k2"))
+		}
+		Expect(service.Get(ctx, "pii:plain")).To(Equal("plain"))
+		Expect(service.TTL(ctx, "pii:1")).To(BeNumerically(">", 0))
+		Expect(service.ReEncrypt(ctx, "pii:*")).To(Equal(0))
+		service.Stop()
+
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key2}})
+		Expect(service.GetValue(ctx, "pii:2", &value, nil)).To(Succeed())
+		Expect(value).To(Equal("secret 2"))
+	})
+
+	It("should publish encrypted messages", func(done Done) {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(service.Publish(ctx, "test-encrypted", []byte("secret"))).To(Succeed())
+			return nil
+		}
+
+		Expect(service.Subscribe(ctx, onSubscribed, func(channel string, data []byte) error {
+			Expect(string(data)).To(Equal("secret"))
+			cancel()
+			return nil
+		}, "test-encrypted")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should decode the messages when strict", func(done Done) {
+		start(EncryptionConfiguration{Keys: []EncryptionKey{key1}, Strict: true})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			return service.Publish(ctx, "test-encrypted", map[string]string{"secret": "value"})
+		}
+
+		Expect(service.SubscribeMessages(ctx, nil, onSubscribed, func(msg *Message) error {
+			var value map[string]string
+			Expect(msg.Decode(&value)).To(Succeed())
+			Expect(value).To(Equal(map[string]string{"secret": "value"}))
+			cancel()
+			return nil
+		}, "test-encrypted")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should not re-encrypt when encryption is disabled", func() {
+		start(EncryptionConfiguration{})
+		_, err := service.ReEncrypt(ctx, "*")
+		Expect(err).To(Equal(ErrEncryptionDisabled))
+	})
+})
@@ -0,0 +1,165 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrUnknownErrorPolicy is returned when the handler error policy is not one
+// of the `ErrorPolicy` constants.
+var ErrUnknownErrorPolicy = errors.New("unknown handler error policy")
+
+// ErrDeadLetterRequired is returned when the dead letter policy has no
+// dead-letter channel or stream.
+var ErrDeadLetterRequired = errors.New("dead letter channel or stream required")
+
+// ErrorPolicy is what a subscription does when its handler fails.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyStop ends the subscription returning the error.
+	ErrorPolicyStop ErrorPolicy = "stop"
+	// ErrorPolicyLog logs the error and continues.
+	ErrorPolicyLog ErrorPolicy = "log"
+	// ErrorPolicyRetry calls the handler again, with a backoff, and ends the
+	// subscription if it still fails.
+	ErrorPolicyRetry ErrorPolicy = "retry"
+	// ErrorPolicyDeadLetter sends the message, with the error, to the
+	// dead-letter channel or stream and continues. It also retries first,
+	// if set.
+	ErrorPolicyDeadLetter ErrorPolicy = "dead_letter"
+)
+
+// HandlerErrorConfiguration is the configuration of what the subscriptions
+// do when their handler fails. It applies to `Subscribe`, `PSubscribe` and
+// their variants. Since a `Subscriber` is never stopped, it logs the errors
+// that would stop a subscription.
+type HandlerErrorConfiguration struct {
+	// Policy defaults to "stop".
+	Policy ErrorPolicy `yaml:"policy"`
+	// Retries is the number of times the handler is called again, only with
+	// the retry and dead letter policies. Defaults to 3 with the retry
+	// policy.
+	Retries int `yaml:"retries"`
+	// Backoff is the wait before the first retry, doubled after each one.
+	// Defaults to 100 milliseconds.
+	Backoff time.Duration `yaml:"backoff"`
+	// DeadLetter is the channel, or stream, the failed messages are sent
+	// to. Required by the dead letter policy.
+	DeadLetter string `yaml:"dead_letter"`
+	// DeadLetterStream sends the failed messages to a stream, using
+	// `StreamAdd`, instead of publishing them.
+	DeadLetterStream bool `yaml:"dead_letter_stream"`
+}
+
+// DeadLetter is a message whose handler failed, sent to the dead-letter
+// channel or stream serialized with the configured codec.
+type DeadLetter struct {
+	Channel  string    `json:"channel"`
+	Data     []byte    `json:"data"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+func (config *HandlerErrorConfiguration) apply() error {
+	if config.Policy == "" {
+		config.Policy = ErrorPolicyStop
+	}
+	switch config.Policy {
+	case ErrorPolicyStop, ErrorPolicyLog, ErrorPolicyDeadLetter:
+	case ErrorPolicyRetry:
+		if config.Retries == 0 {
+			config.Retries = 3
+		}
+	default:
+		return ErrUnknownErrorPolicy
+	}
+	if config.Policy == ErrorPolicyDeadLetter && config.DeadLetter == "" {
+		return ErrDeadLetterRequired
+	}
+	if config.Backoff == 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+	return nil
+}
+
+// handleMessage calls the handler of a message applying the error policy.
+// It returns an error only when the subscription must stop. The handler
+// errors are counted apart from the failures of the subscriptions.
+func (service *RedigoService) handleMessage(ctx context.Context, channel string, data []byte, handler func() error) error {
+	err := handler()
+	if dispatched, ok := err.(*dispatcherError); ok {
+		// The error policy was applied by the worker of the dispatcher.
+		return dispatched.err
+	}
+	if err == nil {
+		// Increment to count success
+		service.Collector.subscribeSuccesses.Inc()
+		return nil
+	}
+	return service.handleError(ctx, channel, data, err, handler)
+}
+
+// handleError applies the error policy to a message whose handler failed,
+// calling the handler again with the policies that retry. It returns an
+// error only when the subscription must stop.
+func (service *RedigoService) handleError(ctx context.Context, channel string, data []byte, err error, handler func() error) error {
+	config := service.Configuration.PubSub.HandlerError
+	collector := service.Collector
+
+	retries := 0
+	if config.Policy == ErrorPolicyRetry || config.Policy == ErrorPolicyDeadLetter {
+		retries = config.Retries
+	}
+	backoff := config.Backoff
+	for i := 0; i < retries && err != nil; i++ {
+		// Increment to count retries
+		collector.handlerErrors.With(prometheus.Labels{"action": "retry"}).Inc()
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		err = handler()
+	}
+	if err == nil {
+		// Increment to count success
+		collector.subscribeSuccesses.Inc()
+		return nil
+	}
+
+	switch config.Policy {
+	case ErrorPolicyLog:
+		collector.handlerErrors.With(prometheus.Labels{"action": "log"}).Inc()
+		service.logger().Printf("redigosrv: handler failed on %s: %s", channel, err)
+		return nil
+	case ErrorPolicyDeadLetter:
+		collector.handlerErrors.With(prometheus.Labels{"action": "dead_letter"}).Inc()
+		return service.deadLetter(ctx, channel, data, err)
+	}
+	collector.handlerErrors.With(prometheus.Labels{"action": "stop"}).Inc()
+	return err
+}
+
+// deadLetter sends the failed message to the dead-letter channel or stream.
+func (service *RedigoService) deadLetter(ctx context.Context, channel string, data []byte, handlerErr error) error {
+	config := service.Configuration.PubSub.HandlerError
+	letter := &DeadLetter{
+		Channel:  channel,
+		Data:     data,
+		Error:    handlerErr.Error(),
+		FailedAt: time.Now(),
+	}
+	if config.DeadLetterStream {
+		_, err := service.StreamAdd(ctx, config.DeadLetter, letter, StreamAddOptions{})
+		return err
+	}
+	return service.Publish(ctx, config.DeadLetter, letter)
+}
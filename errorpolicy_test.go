@@ -0,0 +1,281 @@
+package redigosrv
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (HandlerError)", func() {
+	var service RedigoService
+	failure := errors.New("something bad")
+
+	start := func(config HandlerErrorConfiguration) {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			PubSub: PubSubConfiguration{
+				HandlerError: config,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+	}
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	handlerErrors := func(action string) float64 {
+		var metric dto.Metric
+		Expect(service.Collector.handlerErrors.With(prometheus.Labels{"action": action}).Write(&metric)).To(Succeed())
+		return metric.GetCounter().GetValue()
+	}
+
+	failures := func() float64 {
+		var metric dto.Metric
+		Expect(service.Collector.subscribeFailures.Write(&metric)).To(Succeed())
+		return metric.GetCounter().GetValue()
+	}
+
+	It("should stop the subscription by default", func(done Done) {
+		start(HandlerErrorConfiguration{})
+		ctx := context.Background()
+
+		Expect(service.Subscribe(ctx, func() error {
+			return service.Publish(ctx, "test-policy", "message")
+		}, func(channel string, data []byte) error {
+			return failure
+		}, "test-policy")).To(Equal(failure))
+
+		Expect(handlerErrors("stop")).To(Equal(float64(1)))
+		Expect(failures()).To(Equal(float64(0)))
+		close(done)
+	})
+
+	It("should log the error and continue", func(done Done) {
+		start(HandlerErrorConfiguration{Policy: ErrorPolicyLog})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var received []string
+		Expect(service.Subscribe(ctx, func() error {
+			Expect(service.Publish(ctx, "test-policy", []byte("first"))).To(Succeed())
+			return service.Publish(ctx, "test-policy", []byte("second"))
+		}, func(channel string, data []byte) error {
+			received = append(received, string(data))
+			if len(received) == 1 {
+				return failure
+			}
+			cancel()
+			return nil
+		}, "test-policy")).To(Succeed())
+
+		Expect(received).To(Equal([]string{"first", "second"}))
+		Expect(handlerErrors("log")).To(Equal(float64(1)))
+		close(done)
+	})
+
+	It("should retry the handler", func(done Done) {
+		start(HandlerErrorConfiguration{
+			Policy:  ErrorPolicyRetry,
+			Backoff: time.Millisecond,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		calls := 0
+		Expect(service.Subscribe(ctx, func() error {
+			return service.Publish(ctx, "test-policy", "message")
+		}, func(channel string, data []byte) error {
+			calls++
+			if calls < 2 {
+				return failure
+			}
+			cancel()
+			return nil
+		}, "test-policy")).To(Succeed())
+
+		Expect(calls).To(Equal(2))
+		Expect(handlerErrors("retry")).To(Equal(float64(1)))
+		Expect(handlerErrors("stop")).To(Equal(float64(0)))
+		close(done)
+	})
+
+	It("should stop the subscription when the retries are over", func(done Done) {
+		start(HandlerErrorConfiguration{
+			Policy:  ErrorPolicyRetry,
+			Retries: 2,
+			Backoff: time.Millisecond,
+		})
+		ctx := context.Background()
+
+		calls := 0
+		Expect(service.Subscribe(ctx, func() error {
+			return service.Publish(ctx, "test-policy", "message")
+		}, func(channel string, data []byte) error {
+			calls++
+			return failure
+		}, "test-policy")).To(Equal(failure))
+
+		Expect(calls).To(Equal(3))
+		Expect(handlerErrors("retry")).To(Equal(float64(2)))
+		Expect(handlerErrors("stop")).To(Equal(float64(1)))
+		close(done)
+	})
+
+	It("should not retry with the log policy", func(done Done) {
+		start(HandlerErrorConfiguration{
+			Policy:  ErrorPolicyLog,
+			Retries: 2,
+			Backoff: time.Millisecond,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		calls := 0
+		Expect(service.Subscribe(ctx, func() error {
+			Expect(service.Publish(ctx, "test-policy", []byte("failing"))).To(Succeed())
+			return service.Publish(ctx, "test-policy", []byte("last"))
+		}, func(channel string, data []byte) error {
+			if string(data) == "last" {
+				cancel()
+				return nil
+			}
+			calls++
+			return failure
+		}, "test-policy")).To(Succeed())
+
+		Expect(calls).To(Equal(1))
+		Expect(handlerErrors("retry")).To(Equal(float64(0)))
+		close(done)
+	})
+
+	It("should send the message to the dead-letter channel", func(done Done) {
+		start(HandlerErrorConfiguration{
+			Policy:     ErrorPolicyDeadLetter,
+			DeadLetter: "test-policy-dead",
+		})
+		subCtx, cancel := context.WithCancel(context.Background())
+		ctx := context.Background()
+
+		dead := service.SubscribeChan(subCtx, "test-policy-dead")
+		Expect(dead.Err()).ToNot(HaveOccurred())
+
+		go func() {
+			defer GinkgoRecover()
+
+			var msg *Message
+			Eventually(dead.Messages()).Should(Receive(&msg))
+			var letter DeadLetter
+			Expect(msg.Decode(&letter)).To(Succeed())
+			Expect(letter.Channel).To(Equal("test-policy"))
+			Expect(letter.Data).To(Equal([]byte("message")))
+			Expect(letter.Error).To(Equal(failure.Error()))
+			Expect(letter.FailedAt).ToNot(BeZero())
+			cancel()
+		}()
+
+		Expect(service.Subscribe(subCtx, func() error {
+			return service.Publish(ctx, "test-policy", []byte("message"))
+		}, func(channel string, data []byte) error {
+			return failure
+		}, "test-policy")).To(Succeed())
+
+		Eventually(dead.Done()).Should(BeClosed())
+		Expect(handlerErrors("dead_letter")).To(Equal(float64(1)))
+		close(done)
+	})
+
+	It("should add the message to the dead-letter stream", func(done Done) {
+		start(HandlerErrorConfiguration{
+			Policy:           ErrorPolicyDeadLetter,
+			DeadLetter:       "test-policy-dead",
+			DeadLetterStream: true,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := service.Do(ctx, "DEL", "test-policy-dead")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(service.Subscribe(ctx, func() error {
+			Expect(service.Publish(ctx, "test-policy", []byte("failing"))).To(Succeed())
+			return service.Publish(ctx, "test-policy", []byte("last"))
+		}, func(channel string, data []byte) error {
+			if string(data) == "last" {
+				cancel()
+				return nil
+			}
+			return failure
+		}, "test-policy")).To(Succeed())
+
+		reply, err := service.Do(context.Background(), "XRANGE", "test-policy-dead", "-", "+")
+		Expect(err).ToNot(HaveOccurred())
+		entries, err := parseStreamEntries(reply)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+
+		var letter DeadLetter
+		Expect(service.Unmarshal(nil, entries[0].data, &letter)).To(Succeed())
+		Expect(letter.Channel).To(Equal("test-policy"))
+		Expect(letter.Data).To(Equal([]byte("failing")))
+		Expect(letter.Error).To(Equal(failure.Error()))
+		close(done)
+	})
+
+	It("should apply the policy to the durable messages that cannot be read", func(done Done) {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			PubSub: PubSubConfiguration{
+				HandlerError: HandlerErrorConfiguration{Policy: ErrorPolicyLog},
+				Durable: DurablePubSubConfiguration{
+					Enabled:    true,
+					Subscriber: "subscriber",
+				},
+			},
+			Encryption: EncryptionConfiguration{
+				Keys:   []EncryptionKey{{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", 32)))}},
+				Strict: true,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := service.Do(ctx, "DEL", durableStreamPrefix+"test-policy")
+		Expect(err).ToNot(HaveOccurred())
+
+		var received []string
+		Expect(service.Subscribe(ctx, func() error {
+			// Not encrypted, so it fails to be read in strict mode.
+			_, err := service.Do(ctx, "XADD", streamAddArgs(durableStreamPrefix+"test-policy", []byte("plain"), StreamAddOptions{})...)
+			Expect(err).ToNot(HaveOccurred())
+			return service.Publish(ctx, "test-policy", []byte("encrypted"))
+		}, func(channel string, data []byte) error {
+			received = append(received, string(data))
+			cancel()
+			return nil
+		}, "test-policy")).To(Succeed())
+
+		Expect(received).To(Equal([]string{"encrypted"}))
+		Expect(handlerErrors("log")).To(Equal(float64(1)))
+		pending, err := redis.Values(service.Do(context.Background(), "XPENDING", durableStreamPrefix+"test-policy", "subscriber"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending[0]).To(Equal(int64(0)))
+		close(done)
+	})
+
+	It("should fail with an invalid configuration", func() {
+		var other RedigoService
+		Expect(other.ApplyConfiguration(Configuration{
+			PubSub: PubSubConfiguration{
+				HandlerError: HandlerErrorConfiguration{Policy: "unknown"},
+			},
+		})).To(Equal(ErrUnknownErrorPolicy))
+		Expect(other.ApplyConfiguration(Configuration{
+			PubSub: PubSubConfiguration{
+				HandlerError: HandlerErrorConfiguration{Policy: ErrorPolicyDeadLetter},
+			},
+		})).To(Equal(ErrDeadLetterRequired))
+	})
+})
@@ -3,8 +3,10 @@ module github.com/lab259/go-rscsrv-redigo
 go 1.12
 
 require (
+	github.com/golang/protobuf v1.3.2
 	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/jamillosantos/macchiato v0.0.0-20171220130318-3be045cc5033
+	github.com/klauspost/compress v1.9.8
 	github.com/lab259/go-rscsrv v0.2.0
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
@@ -13,4 +15,5 @@ require (
 	github.com/onsi/gomega v1.5.0
 	github.com/prometheus/client_golang v1.3.0
 	github.com/prometheus/client_model v0.1.0
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
 )
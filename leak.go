@@ -0,0 +1,132 @@
+package redigosrv
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LeakDetectionConfiguration is the configuration for detecting connections
+// acquired with `GetConn` that were never closed.
+type LeakDetectionConfiguration struct {
+	Enabled bool `yaml:"enabled"`
+	// Threshold is how long a connection can be held before being reported.
+	Threshold time.Duration `yaml:"threshold"`
+	// CheckInterval is the period in which checked out connections are
+	// verified.
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// CheckedOutConn describes a connection acquired with `GetConn` that was not
+// closed yet.
+type CheckedOutConn struct {
+	ID         uint64
+	AcquiredAt time.Time
+	// Stack is the stack trace of the goroutine that acquired the connection.
+	Stack string
+	// Leaked is true when the connection is held longer than the threshold.
+	Leaked bool
+}
+
+type leakTracker struct {
+	sync.Mutex
+	config    LeakDetectionConfiguration
+	conns     map[uint64]*CheckedOutConn
+	lastID    uint64
+	logger    Logger
+	collector *RedigoCollector
+	done      chan struct{}
+}
+
+func newLeakTracker(config LeakDetectionConfiguration, logger Logger, collector *RedigoCollector) *leakTracker {
+	return &leakTracker{
+		config:    config,
+		conns:     make(map[uint64]*CheckedOutConn),
+		logger:    logger,
+		collector: collector,
+		done:      make(chan struct{}),
+	}
+}
+
+// acquired records a new checked out connection returning its id.
+func (tracker *leakTracker) acquired() uint64 {
+	stack := string(debug.Stack())
+
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	tracker.lastID++
+	tracker.conns[tracker.lastID] = &CheckedOutConn{
+		ID:         tracker.lastID,
+		AcquiredAt: time.Now(),
+		Stack:      stack,
+	}
+	return tracker.lastID
+}
+
+// released removes a connection from the checked out list.
+func (tracker *leakTracker) released(id uint64) {
+	tracker.Lock()
+	delete(tracker.conns, id)
+	tracker.Unlock()
+}
+
+// check reports connections held longer than the threshold. Each connection
+// is reported only once.
+func (tracker *leakTracker) check() {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	for _, conn := range tracker.conns {
+		if conn.Leaked || time.Since(conn.AcquiredAt) < tracker.config.Threshold {
+			continue
+		}
+		conn.Leaked = true
+		tracker.collector.connectionsLeaked.Inc()
+		tracker.logger.Printf("redigosrv: connection %d held for more than %s, acquired at:\n%s", conn.ID, tracker.config.Threshold, conn.Stack)
+	}
+}
+
+// list returns a copy of the checked out connections ordered by acquisition.
+func (tracker *leakTracker) list() []CheckedOutConn {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	conns := make([]CheckedOutConn, 0, len(tracker.conns))
+	for _, conn := range tracker.conns {
+		conns = append(conns, *conn)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+	return conns
+}
+
+// run checks for leaks periodically until `stop` is called.
+func (tracker *leakTracker) run() {
+	ticker := time.NewTicker(tracker.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tracker.check()
+		case <-tracker.done:
+			return
+		}
+	}
+}
+
+func (tracker *leakTracker) stop() {
+	close(tracker.done)
+}
+
+// CheckedOutConns lists the connections acquired with `GetConn` that were not
+// closed yet. It returns nil when the leak detection is disabled.
+func (service *RedigoService) CheckedOutConns() []CheckedOutConn {
+	if service.leaks == nil {
+		return nil
+	}
+	return service.leaks.list()
+}
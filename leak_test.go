@@ -0,0 +1,90 @@
+package redigosrv
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeLogger struct {
+	messages []string
+}
+
+func (logger *fakeLogger) Printf(format string, v ...interface{}) {
+	logger.messages = append(logger.messages, fmt.Sprintf(format, v...))
+}
+
+var _ = Describe("Leak detection", func() {
+	It("should list checked out connections", func() {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			LeakDetection: LeakDetectionConfiguration{
+				Enabled: true,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+
+		conn, err := service.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+
+		conns := service.CheckedOutConns()
+		Expect(conns).To(HaveLen(1))
+		Expect(conns[0].Stack).To(ContainSubstring("leak_test.go"))
+		Expect(conns[0].Leaked).To(BeFalse())
+
+		Expect(conn.Close()).To(Succeed())
+		Expect(service.CheckedOutConns()).To(BeEmpty())
+	})
+
+	It("should report connections held longer than the threshold", func() {
+		logger := &fakeLogger{}
+		service := RedigoService{
+			Logger: logger,
+		}
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			LeakDetection: LeakDetectionConfiguration{
+				Enabled:       true,
+				Threshold:     time.Millisecond,
+				CheckInterval: time.Hour,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+
+		conn, err := service.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		time.Sleep(2 * time.Millisecond)
+		service.leaks.check()
+		service.leaks.check()
+
+		Expect(service.CheckedOutConns()[0].Leaked).To(BeTrue())
+		Expect(logger.messages).To(HaveLen(1))
+		Expect(logger.messages[0]).To(ContainSubstring("leak_test.go"))
+
+		var metric dto.Metric
+		Expect(service.Collector.connectionsLeaked.Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should not track connections when disabled", func() {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		defer service.Stop()
+
+		conn, err := service.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(service.CheckedOutConns()).To(BeNil())
+	})
+})
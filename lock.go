@@ -0,0 +1,290 @@
+package redigosrv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrLockNotAcquired is returned when the lock could not be acquired before
+// the wait timeout.
+var ErrLockNotAcquired = errors.New("lock not acquired")
+
+// ErrLockNotHeld is returned when releasing a lock that is not held anymore.
+var ErrLockNotHeld = errors.New("lock not held")
+
+const (
+	lockReleaseScriptName = "redigosrv:lock:release"
+	lockReleaseScript     = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+	lockExtendScriptName  = "redigosrv:lock:extend"
+	lockExtendScript      = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+// LockOptions are the options for acquiring a lock.
+type LockOptions struct {
+	// TTL is the duration of the lease. While the lock is held, the lease is
+	// extended every third of it. Defaults to 30 seconds.
+	TTL time.Duration
+	// WaitTimeout is how long to keep trying to acquire the lock. If zero,
+	// it is tried only once.
+	WaitTimeout time.Duration
+	// RetryInterval is the time between attempts. Defaults to 100
+	// milliseconds.
+	RetryInterval time.Duration
+}
+
+func (opts LockOptions) withDefaults() LockOptions {
+	if opts.TTL == 0 {
+		opts.TTL = 30 * time.Second
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = 100 * time.Millisecond
+	}
+	return opts
+}
+
+// Redlock acquires locks in multiple independent Redis instances. The lock is
+// held when it is acquired in the majority of them.
+type Redlock struct {
+	services []*RedigoService
+}
+
+// NewRedlock returns a `Redlock` for the given services, which must be
+// started and point to independent Redis instances.
+func NewRedlock(services ...*RedigoService) *Redlock {
+	return &Redlock{
+		services: services,
+	}
+}
+
+// Lock is a held distributed lock. While held, its lease is extended
+// automatically and, if it is lost, its context is cancelled.
+type Lock struct {
+	redlock *Redlock
+	key     string
+	token   string
+	ttl     time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// AcquireLock acquires a lock on the key. The lock must be released using
+// `Release`.
+func (service *RedigoService) AcquireLock(ctx context.Context, key string, opts LockOptions) (*Lock, error) {
+	return NewRedlock(service).Acquire(ctx, key, opts)
+}
+
+// quorum is the number of instances that must agree.
+func (redlock *Redlock) quorum() int {
+	return len(redlock.services)/2 + 1
+}
+
+// Acquire acquires a lock on the key, retrying until the wait timeout. The
+// lock must be released using `Release`.
+func (redlock *Redlock) Acquire(ctx context.Context, key string, opts LockOptions) (*Lock, error) {
+	opts = opts.withDefaults()
+
+	for _, service := range redlock.services {
+		if err := registerLockScripts(service); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	collector := redlock.services[0].Collector
+	start := time.Now()
+	deadline := start.Add(opts.WaitTimeout)
+	for {
+		acquired, err := redlock.tryAcquire(ctx, key, token, opts.TTL)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		// Increment to count contentions
+		collector.lockContentions.Inc()
+
+		if !time.Now().Add(opts.RetryInterval).Before(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+		select {
+		case <-time.After(opts.RetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	collector.lockWaitDuration.Observe(time.Since(start).Seconds())
+
+	lockCtx, cancel := context.WithCancel(context.Background())
+	lock := &Lock{
+		redlock: redlock,
+		key:     key,
+		token:   token,
+		ttl:     opts.TTL,
+		ctx:     lockCtx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go lock.keepAlive()
+	return lock, nil
+}
+
+// tryAcquire tries to acquire the lock in all instances. If the quorum is not
+// reached, or the lease expired while acquiring, it is released in all of
+// them.
+func (redlock *Redlock) tryAcquire(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	acquired := 0
+	var lastErr error
+	for _, service := range redlock.services {
+		ok, err := service.SetNX(ctx, key, token, ttl)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ok {
+			acquired++
+		}
+	}
+
+	// The clock drift is 1% of the ttl plus 2 milliseconds, as suggested by
+	// the Redlock algorithm.
+	drift := ttl/100 + 2*time.Millisecond
+	if acquired >= redlock.quorum() && time.Since(start)+drift < ttl {
+		return true, nil
+	}
+	redlock.release(context.Background(), key, token)
+	if acquired == 0 && lastErr != nil && len(redlock.services) == 1 {
+		return false, lastErr
+	}
+	return false, nil
+}
+
+// release runs the release script in all instances returning how many
+// released the lock.
+func (redlock *Redlock) release(ctx context.Context, key, token string) (int, error) {
+	released := 0
+	var lastErr error
+	for _, service := range redlock.services {
+		n, err := redis.Int(service.EvalScript(ctx, lockReleaseScriptName, key, token))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		released += n
+	}
+	return released, lastErr
+}
+
+// extend runs the extend script in all instances returning how many extended
+// the lease.
+func (redlock *Redlock) extend(ctx context.Context, key, token string, ttl time.Duration) (int, error) {
+	extended := 0
+	var lastErr error
+	for _, service := range redlock.services {
+		n, err := redis.Int(service.EvalScript(ctx, lockExtendScriptName, key, token, int64(ttl/time.Millisecond)))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		extended += n
+	}
+	return extended, lastErr
+}
+
+// Key returns the locked key.
+func (lock *Lock) Key() string {
+	return lock.key
+}
+
+// Context returns a context that is cancelled when the lock is released or
+// its lease is lost.
+func (lock *Lock) Context() context.Context {
+	return lock.ctx
+}
+
+// keepAlive extends the lease every third of the ttl until the lock is
+// released. If the lease cannot be extended before it expires, the lock is
+// considered lost.
+func (lock *Lock) keepAlive() {
+	defer close(lock.stopped)
+
+	ticker := time.NewTicker(lock.ttl / 3)
+	defer ticker.Stop()
+
+	expiresAt := time.Now().Add(lock.ttl)
+	for {
+		select {
+		case <-lock.done:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			extended, err := lock.redlock.extend(lock.ctx, lock.key, lock.token, lock.ttl)
+			if extended >= lock.redlock.quorum() {
+				expiresAt = start.Add(lock.ttl)
+				continue
+			}
+			if err == nil || !time.Now().Before(expiresAt) {
+				// Increment to count lost locks
+				lock.redlock.services[0].Collector.lockLost.Inc()
+
+				lock.stop()
+				return
+			}
+		}
+	}
+}
+
+// stop stops the lease extension and cancels the context of the lock.
+func (lock *Lock) stop() {
+	lock.once.Do(func() {
+		close(lock.done)
+		lock.cancel()
+	})
+}
+
+// Release releases the lock. It returns `ErrLockNotHeld` if the lock was
+// already lost.
+func (lock *Lock) Release(ctx context.Context) error {
+	lock.stop()
+	// Waits for an extension in progress, so it does not outlive the lock.
+	<-lock.stopped
+	released, err := lock.redlock.release(ctx, lock.key, lock.token)
+	if released >= lock.redlock.quorum() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+func registerLockScripts(service *RedigoService) error {
+	if err := service.RegisterScript(lockReleaseScriptName, 1, lockReleaseScript); err != nil {
+		return err
+	}
+	return service.RegisterScript(lockExtendScriptName, 1, lockExtendScript)
+}
+
+func newLockToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
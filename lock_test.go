@@ -0,0 +1,116 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Lock)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "lock-key")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should acquire and release a lock", func() {
+		lock, err := service.AcquireLock(ctx, "lock-key", LockOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lock.Key()).To(Equal("lock-key"))
+
+		_, err = service.AcquireLock(ctx, "lock-key", LockOptions{})
+		Expect(err).To(Equal(ErrLockNotAcquired))
+
+		var metric dto.Metric
+		Expect(service.Collector.lockContentions.Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+
+		Expect(lock.Release(ctx)).To(Succeed())
+		Expect(lock.Context().Err()).To(Equal(context.Canceled))
+		Expect(lock.Release(ctx)).To(Equal(ErrLockNotHeld))
+	})
+
+	It("should wait for the lock to be released", func() {
+		lock, err := service.AcquireLock(ctx, "lock-key", LockOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(50 * time.Millisecond)
+			Expect(lock.Release(ctx)).To(Succeed())
+		}()
+
+		other, err := service.AcquireLock(ctx, "lock-key", LockOptions{
+			WaitTimeout:   time.Second,
+			RetryInterval: 10 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Release(ctx)).To(Succeed())
+
+		var metric dto.Metric
+		Expect(service.Collector.lockWaitDuration.Write(&metric)).To(Succeed())
+		Expect(metric.GetHistogram().GetSampleSum()).To(BeNumerically(">=", 0.05))
+	})
+
+	It("should extend the lease while the lock is held", func() {
+		lock, err := service.AcquireLock(ctx, "lock-key", LockOptions{
+			TTL: 300 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer lock.Release(ctx)
+
+		time.Sleep(400 * time.Millisecond)
+		Expect(lock.Context().Err()).ToNot(HaveOccurred())
+		Expect(service.TTL(ctx, "lock-key")).To(BeNumerically(">", 150*time.Millisecond))
+	})
+
+	It("should cancel the context when the lease is lost", func() {
+		lock, err := service.AcquireLock(ctx, "lock-key", LockOptions{
+			TTL: 150 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = service.Del(ctx, "lock-key")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(lock.Context().Done()).Should(BeClosed())
+		Expect(lock.Release(ctx)).To(Equal(ErrLockNotHeld))
+
+		var metric dto.Metric
+		Expect(service.Collector.lockLost.Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should not acquire a redlock without quorum", func() {
+		lock, err := service.AcquireLock(ctx, "lock-key", LockOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		defer lock.Release(ctx)
+
+		var other RedigoService
+		Expect(other.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(other.Start()).To(Succeed())
+		defer other.Stop()
+
+		// Both services point to the same instance, so only one of them can
+		// acquire the lock.
+		Expect(service.Del(ctx, "lock-key")).To(Equal(int64(1)))
+		_, err = NewRedlock(&service, &other).Acquire(ctx, "lock-key", LockOptions{})
+		Expect(err).To(Equal(ErrLockNotAcquired))
+		Expect(service.Exists(ctx, "lock-key")).To(Equal(int64(0)))
+	})
+})
@@ -0,0 +1,496 @@
+package redigosrv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNamespaceUnsupported is returned when a namespace is configured and the
+// command is not known to be safe, as its keys could not be namespaced.
+var ErrNamespaceUnsupported = errors.New("command not supported with a namespace")
+
+// keySpec is the position of the keys in the arguments of a command (not
+// counting the command name), as in the COMMAND output of Redis: the first
+// key, the last key (negative values count from the end) and the step
+// between keys.
+type keySpec struct {
+	first, last, step int
+}
+
+var (
+	oneKey       = keySpec{0, 0, 1}
+	twoKeys      = keySpec{0, 1, 1}
+	allKeys      = keySpec{0, -1, 1}
+	allButLast   = keySpec{0, -2, 1}
+	keyValuePair = keySpec{0, -1, 2}
+)
+
+// commandKeys is the key position table of the commands whose keys are
+// namespaced. Commands with keys in variable positions are handled by
+// `namespace.args`. Commands neither here nor in `keylessCommands` are
+// rejected.
+var commandKeys = map[string]keySpec{
+	// Keys
+	"DEL": allKeys, "UNLINK": allKeys, "EXISTS": allKeys, "TOUCH": allKeys,
+	"EXPIRE": oneKey, "PEXPIRE": oneKey, "EXPIREAT": oneKey, "PEXPIREAT": oneKey,
+	"EXPIRETIME": oneKey, "PEXPIRETIME": oneKey, "PERSIST": oneKey,
+	"TTL": oneKey, "PTTL": oneKey, "TYPE": oneKey, "DUMP": oneKey,
+	"RESTORE": oneKey, "RENAME": twoKeys, "RENAMENX": twoKeys, "COPY": twoKeys,
+	"MOVE": oneKey, "WATCH": allKeys, "SORT": oneKey, "SORT_RO": oneKey,
+	"OBJECT": keySpec{1, 1, 1},
+
+	// Strings
+	"GET": oneKey, "SET": oneKey, "SETNX": oneKey, "SETEX": oneKey,
+	"PSETEX": oneKey, "GETSET": oneKey, "GETDEL": oneKey, "GETEX": oneKey,
+	"APPEND": oneKey, "STRLEN": oneKey, "INCR": oneKey, "INCRBY": oneKey,
+	"INCRBYFLOAT": oneKey, "DECR": oneKey, "DECRBY": oneKey,
+	"GETRANGE": oneKey, "SETRANGE": oneKey, "GETBIT": oneKey, "SETBIT": oneKey,
+	"BITCOUNT": oneKey, "BITPOS": oneKey, "BITFIELD": oneKey,
+	"BITFIELD_RO": oneKey, "SUBSTR": oneKey, "LCS": twoKeys,
+	"MGET": allKeys, "MSET": keyValuePair, "MSETNX": keyValuePair,
+	"BITOP": keySpec{1, -1, 1},
+
+	// Hashes
+	"HGET": oneKey, "HSET": oneKey, "HSETNX": oneKey, "HMSET": oneKey,
+	"HMGET": oneKey, "HGETALL": oneKey, "HDEL": oneKey, "HEXISTS": oneKey,
+	"HINCRBY": oneKey, "HINCRBYFLOAT": oneKey, "HKEYS": oneKey, "HVALS": oneKey,
+	"HLEN": oneKey, "HSTRLEN": oneKey, "HSCAN": oneKey, "HRANDFIELD": oneKey,
+
+	// Lists
+	"LPUSH": oneKey, "RPUSH": oneKey, "LPUSHX": oneKey, "RPUSHX": oneKey,
+	"LPOP": oneKey, "RPOP": oneKey, "LRANGE": oneKey, "LLEN": oneKey,
+	"LINDEX": oneKey, "LSET": oneKey, "LREM": oneKey, "LTRIM": oneKey,
+	"LINSERT": oneKey, "LPOS": oneKey, "RPOPLPUSH": twoKeys, "LMOVE": twoKeys,
+	"BRPOPLPUSH": twoKeys, "BLMOVE": twoKeys, "BLPOP": allButLast,
+	"BRPOP": allButLast, "LMPOP": keySpec{}, "BLMPOP": keySpec{},
+
+	// Sets
+	"SADD": oneKey, "SREM": oneKey, "SMEMBERS": oneKey, "SISMEMBER": oneKey,
+	"SMISMEMBER": oneKey, "SCARD": oneKey, "SPOP": oneKey,
+	"SRANDMEMBER": oneKey, "SSCAN": oneKey, "SMOVE": twoKeys,
+	"SUNION": allKeys, "SINTER": allKeys, "SDIFF": allKeys,
+	"SUNIONSTORE": allKeys, "SINTERSTORE": allKeys, "SDIFFSTORE": allKeys,
+	"SINTERCARD": keySpec{},
+
+	// Sorted sets
+	"ZADD": oneKey, "ZREM": oneKey, "ZSCORE": oneKey, "ZMSCORE": oneKey,
+	"ZINCRBY": oneKey, "ZRANGE": oneKey, "ZREVRANGE": oneKey,
+	"ZRANGEBYSCORE": oneKey, "ZREVRANGEBYSCORE": oneKey,
+	"ZRANGEBYLEX": oneKey, "ZREVRANGEBYLEX": oneKey, "ZCARD": oneKey,
+	"ZCOUNT": oneKey, "ZLEXCOUNT": oneKey, "ZRANK": oneKey, "ZREVRANK": oneKey,
+	"ZREMRANGEBYRANK": oneKey, "ZREMRANGEBYSCORE": oneKey,
+	"ZREMRANGEBYLEX": oneKey, "ZPOPMIN": oneKey, "ZPOPMAX": oneKey,
+	"ZSCAN": oneKey, "ZRANDMEMBER": oneKey, "BZPOPMIN": allButLast,
+	"BZPOPMAX": allButLast, "ZRANGESTORE": twoKeys,
+	"ZUNIONSTORE": keySpec{}, "ZINTERSTORE": keySpec{}, "ZDIFFSTORE": keySpec{},
+	"ZUNION": keySpec{}, "ZINTER": keySpec{}, "ZDIFF": keySpec{},
+	"ZINTERCARD": keySpec{}, "ZMPOP": keySpec{}, "BZMPOP": keySpec{},
+
+	// Streams
+	"XADD": oneKey, "XLEN": oneKey, "XRANGE": oneKey, "XREVRANGE": oneKey,
+	"XDEL": oneKey, "XTRIM": oneKey, "XACK": oneKey, "XPENDING": oneKey,
+	"XCLAIM": oneKey, "XAUTOCLAIM": oneKey, "XSETID": oneKey,
+	"XGROUP": keySpec{1, 1, 1}, "XINFO": keySpec{1, 1, 1},
+	"XREAD": keySpec{}, "XREADGROUP": keySpec{},
+
+	// HyperLogLog and geo
+	"PFADD": oneKey, "PFCOUNT": allKeys, "PFMERGE": allKeys,
+	"GEOADD": oneKey, "GEOPOS": oneKey, "GEODIST": oneKey, "GEOHASH": oneKey,
+	"GEORADIUS": oneKey, "GEORADIUSBYMEMBER": oneKey, "GEORADIUS_RO": oneKey,
+	"GEORADIUSBYMEMBER_RO": oneKey, "GEOSEARCH": oneKey,
+	"GEOSEARCHSTORE": twoKeys,
+
+	// Scripts
+	"EVAL": keySpec{}, "EVALSHA": keySpec{}, "EVAL_RO": keySpec{},
+	"EVALSHA_RO": keySpec{}, "FCALL": keySpec{}, "FCALL_RO": keySpec{},
+
+	// Server
+	"KEYS": keySpec{}, "SCAN": keySpec{}, "MEMORY": keySpec{},
+
+	// Pub/sub channels
+	"PUBLISH": oneKey, "SPUBLISH": oneKey, "PUBSUB": keySpec{},
+}
+
+// keyOptions are the options followed by a key, or a key pattern, of the
+// commands with optional keys.
+var keyOptions = map[string][]string{
+	"SORT":                 {"BY", "GET", "STORE"},
+	"SORT_RO":              {"BY", "GET"},
+	"GEORADIUS":            {"STORE", "STOREDIST"},
+	"GEORADIUSBYMEMBER":    {"STORE", "STOREDIST"},
+	"GEORADIUS_RO":         {},
+	"GEORADIUSBYMEMBER_RO": {},
+}
+
+// keylessCommands are the commands without keys that can be sent when a
+// namespace is configured. The commands affecting the keys of every
+// namespace, like FLUSHDB, are not.
+var keylessCommands = map[string]bool{
+	"PING": true, "ECHO": true, "AUTH": true, "HELLO": true, "SELECT": true,
+	"QUIT": true, "RESET": true, "MULTI": true, "EXEC": true, "DISCARD": true,
+	"UNWATCH": true, "SCRIPT": true, "CLIENT": true, "COMMAND": true,
+	"INFO": true, "TIME": true, "LASTSAVE": true, "ROLE": true, "WAIT": true,
+	"READONLY": true, "READWRITE": true, "RANDOMKEY": true,
+
+	// An empty command flushes the commands sent and receives their replies.
+	"": true,
+}
+
+// namespace is the prefix of the keys and channels, including the separator.
+// The empty namespace keeps them as they are.
+type namespace string
+
+// namespace returns the configured namespace.
+func (service *RedigoService) namespace() namespace {
+	if service.Configuration.Namespace == "" {
+		return ""
+	}
+	return namespace(service.Configuration.Namespace + ":")
+}
+
+// key adds the namespace to the key.
+func (ns namespace) key(key string) string {
+	return string(ns) + key
+}
+
+// strip removes the namespace from the key.
+func (ns namespace) strip(key string) string {
+	return strings.TrimPrefix(key, string(ns))
+}
+
+// arg adds the namespace to a key argument.
+func (ns namespace) arg(arg interface{}) interface{} {
+	switch a := arg.(type) {
+	case string:
+		return ns.key(a)
+	case []byte:
+		return append([]byte(ns), a...)
+	default:
+		return ns.key(fmt.Sprint(a))
+	}
+}
+
+// args returns a copy of the arguments of the command with the namespace
+// added to its keys. It fails with `ErrNamespaceUnsupported` for the
+// commands whose keys are unknown.
+func (ns namespace) args(commandName string, args []interface{}) ([]interface{}, error) {
+	if ns == "" {
+		return args, nil
+	}
+	commandName = strings.ToUpper(commandName)
+	if keylessCommands[commandName] {
+		return args, nil
+	}
+	spec, ok := commandKeys[commandName]
+	if !ok {
+		return nil, ErrNamespaceUnsupported
+	}
+	if len(args) == 0 {
+		return args, nil
+	}
+	args = append([]interface{}(nil), args...)
+
+	switch commandName {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		ns.numKeys(args, 1)
+		return args, nil
+	case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+		args[0] = ns.arg(args[0])
+		ns.numKeys(args, 1)
+		return args, nil
+	case "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD", "SINTERCARD", "LMPOP", "ZMPOP":
+		ns.numKeys(args, 0)
+		return args, nil
+	case "BLMPOP", "BZMPOP":
+		ns.numKeys(args, 1)
+		return args, nil
+	case "MEMORY":
+		if s, ok := args[0].(string); ok && strings.ToUpper(s) == "USAGE" && len(args) > 1 {
+			args[1] = ns.arg(args[1])
+		}
+		return args, nil
+	case "PUBSUB":
+		return ns.pubsubArgs(args), nil
+	case "XREAD", "XREADGROUP":
+		// The keys are the first half of the arguments after STREAMS.
+		for i, arg := range args {
+			if s, ok := arg.(string); ok && strings.ToUpper(s) == "STREAMS" {
+				streams := args[i+1:]
+				for j := 0; j < len(streams)/2; j++ {
+					streams[j] = ns.arg(streams[j])
+				}
+				break
+			}
+		}
+		return args, nil
+	case "KEYS":
+		args[0] = ns.arg(args[0])
+		return args, nil
+	case "SCAN":
+		return ns.scanArgs(args), nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	for i := spec.first; i <= last && i < len(args); i += spec.step {
+		args[i] = ns.arg(args[i])
+	}
+	ns.options(args, keyOptions[commandName])
+	return args, nil
+}
+
+// options adds the namespace to the keys, or key patterns, following the
+// options, after the first argument. The `GET #` of SORT is kept as it is.
+func (ns namespace) options(args []interface{}, options []string) {
+	for i := 1; i < len(args)-1; i++ {
+		s, ok := args[i].(string)
+		if !ok {
+			continue
+		}
+		option := strings.ToUpper(s)
+		for _, name := range options {
+			if option != name {
+				continue
+			}
+			if next, ok := args[i+1].(string); !ok || option != "GET" || next != "#" {
+				args[i+1] = ns.arg(args[i+1])
+			}
+			i++
+			break
+		}
+	}
+}
+
+// pubsubArgs adds the namespace to the channels of PUBSUB NUMSUB and to the
+// pattern of PUBSUB CHANNELS, matching only the channels of the namespace
+// when no pattern is given.
+func (ns namespace) pubsubArgs(args []interface{}) []interface{} {
+	s, _ := args[0].(string)
+	switch strings.ToUpper(s) {
+	case "NUMSUB", "SHARDNUMSUB":
+		for i := 1; i < len(args); i++ {
+			args[i] = ns.arg(args[i])
+		}
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 1 {
+			args[1] = ns.arg(args[1])
+		} else {
+			args = append(args, ns.key("*"))
+		}
+	}
+	return args
+}
+
+// numKeys adds the namespace to the keys following the number of keys at
+// the given position.
+func (ns namespace) numKeys(args []interface{}, position int) {
+	if position >= len(args) {
+		return
+	}
+	n, err := strconv.Atoi(fmt.Sprint(args[position]))
+	if err != nil {
+		return
+	}
+	for i := position + 1; i <= position+n && i < len(args); i++ {
+		args[i] = ns.arg(args[i])
+	}
+}
+
+// scanArgs adds the namespace to the MATCH pattern, matching only the keys of
+// the namespace when no pattern is given.
+func (ns namespace) scanArgs(args []interface{}) []interface{} {
+	for i := 1; i < len(args)-1; i++ {
+		if s, ok := args[i].(string); ok && strings.ToUpper(s) == "MATCH" {
+			args[i+1] = ns.arg(args[i+1])
+			return args
+		}
+	}
+	return append(args, "MATCH", ns.key("*"))
+}
+
+// reply removes the namespace from the keys returned by the command.
+func (ns namespace) reply(commandName string, reply interface{}) interface{} {
+	if ns == "" {
+		return reply
+	}
+	switch strings.ToUpper(commandName) {
+	case "KEYS", "PUBSUB":
+		return ns.stripAll(reply)
+	case "SCAN":
+		if values, ok := reply.([]interface{}); ok && len(values) == 2 {
+			return []interface{}{values[0], ns.stripAll(values[1])}
+		}
+	case "RANDOMKEY":
+		return ns.stripOne(reply)
+	case "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX", "LMPOP", "BLMPOP", "ZMPOP", "BZMPOP":
+		if values, ok := reply.([]interface{}); ok && len(values) > 0 {
+			values = append([]interface{}(nil), values...)
+			values[0] = ns.stripOne(values[0])
+			return values
+		}
+	case "XREAD", "XREADGROUP":
+		// The reply is a list of stream names and their entries.
+		if streams, ok := reply.([]interface{}); ok {
+			stripped := make([]interface{}, len(streams))
+			for i, stream := range streams {
+				stripped[i] = stream
+				if values, ok := stream.([]interface{}); ok && len(values) == 2 {
+					stripped[i] = []interface{}{ns.stripOne(values[0]), values[1]}
+				}
+			}
+			return stripped
+		}
+	}
+	return reply
+}
+
+func (ns namespace) stripOne(reply interface{}) interface{} {
+	if key, ok := reply.([]byte); ok {
+		return []byte(ns.strip(string(key)))
+	}
+	return reply
+}
+
+func (ns namespace) stripAll(reply interface{}) interface{} {
+	values, ok := reply.([]interface{})
+	if !ok {
+		return reply
+	}
+	stripped := make([]interface{}, len(values))
+	for i, value := range values {
+		stripped[i] = ns.stripOne(value)
+	}
+	return stripped
+}
+
+// namespacedConn adds the namespace to the keys of the commands and removes
+// it from the keys replied. The commands sent are queued so their replies
+// can be handled when received, as are the commands of a transaction so
+// their replies can be handled when returned by EXEC.
+type namespacedConn struct {
+	redis.Conn
+	ns      namespace
+	pending []namespacedCommand
+	multi   bool
+	queued  []string
+}
+
+// namespacedCommand is a command whose reply has not been handled yet.
+type namespacedCommand struct {
+	name string
+	// queued are the commands of the transaction, when the command is EXEC.
+	queued []string
+}
+
+// command keeps track of the transaction the command belongs to.
+func (conn *namespacedConn) command(commandName string) namespacedCommand {
+	command := namespacedCommand{name: strings.ToUpper(commandName)}
+	switch command.name {
+	case "":
+	case "MULTI":
+		conn.multi, conn.queued = true, nil
+	case "EXEC":
+		command.queued = conn.queued
+		conn.multi, conn.queued = false, nil
+	case "DISCARD":
+		conn.multi, conn.queued = false, nil
+	default:
+		if conn.multi {
+			conn.queued = append(conn.queued, command.name)
+		}
+	}
+	return command
+}
+
+// reply removes the namespace from the keys replied to the command.
+func (conn *namespacedConn) reply(command namespacedCommand, reply interface{}) interface{} {
+	if command.name != "EXEC" {
+		return conn.ns.reply(command.name, reply)
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(command.queued) {
+		return reply
+	}
+	stripped := make([]interface{}, len(replies))
+	for i, r := range replies {
+		stripped[i] = conn.ns.reply(command.queued[i], r)
+	}
+	return stripped
+}
+
+// done handles the reply of `Do`, which is the replies of the pending
+// commands for the empty command.
+func (conn *namespacedConn) done(command namespacedCommand, reply interface{}) interface{} {
+	pending := conn.pending
+	conn.pending = conn.pending[:0]
+	if command.name != "" {
+		return conn.reply(command, reply)
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(pending) {
+		return reply
+	}
+	stripped := make([]interface{}, len(replies))
+	for i, r := range replies {
+		stripped[i] = conn.reply(pending[i], r)
+	}
+	return stripped
+}
+
+func (conn *namespacedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	args, err := conn.ns.args(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	command := conn.command(commandName)
+	reply, err := conn.Conn.Do(commandName, args...)
+	return conn.done(command, reply), err
+}
+
+func (conn *namespacedConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	args, err := conn.ns.args(commandName, args)
+	if err != nil {
+		return nil, err
+	}
+	command := conn.command(commandName)
+	reply, err := redis.DoWithTimeout(conn.Conn, timeout, commandName, args...)
+	return conn.done(command, reply), err
+}
+
+func (conn *namespacedConn) Send(commandName string, args ...interface{}) error {
+	args, err := conn.ns.args(commandName, args)
+	if err != nil {
+		return err
+	}
+	if err := conn.Conn.Send(commandName, args...); err != nil {
+		return err
+	}
+	conn.pending = append(conn.pending, conn.command(commandName))
+	return nil
+}
+
+func (conn *namespacedConn) Receive() (interface{}, error) {
+	reply, err := conn.Conn.Receive()
+	return conn.received(reply), err
+}
+
+func (conn *namespacedConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	reply, err := redis.ReceiveWithTimeout(conn.Conn, timeout)
+	return conn.received(reply), err
+}
+
+func (conn *namespacedConn) received(reply interface{}) interface{} {
+	if len(conn.pending) == 0 {
+		return reply
+	}
+	command := conn.pending[0]
+	conn.pending = conn.pending[1:]
+	return conn.reply(command, reply)
+}
@@ -0,0 +1,207 @@
+package redigosrv
+
+import (
+	"context"
+	"sort"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace", func() {
+	var service, raw RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address:   "localhost:6379",
+			Namespace: "tenant",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		Expect(raw.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(raw.Start()).To(Succeed())
+		_, err := raw.Del(ctx, "tenant:a", "tenant:b", "tenant:c", "a", "tenant:stream")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+		raw.Stop()
+	})
+
+	It("should prefix the keys of the commands", func() {
+		Expect(service.Set(ctx, "a", "1", 0)).To(Succeed())
+		Expect(raw.Get(ctx, "tenant:a")).To(Equal("1"))
+		_, err := raw.Get(ctx, "a")
+		Expect(err).To(Equal(ErrNil))
+
+		_, err = service.Do(ctx, "MSET", "b", "2", "c", "3")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redis.Strings(raw.Do(ctx, "MGET", "tenant:b", "tenant:c"))).To(Equal([]string{"2", "3"}))
+		Expect(service.Del(ctx, "a", "b", "c")).To(Equal(int64(3)))
+	})
+
+	It("should prefix the keys of scripts", func() {
+		Expect(service.Set(ctx, "a", "1", 0)).To(Succeed())
+		Expect(redis.String(service.Do(ctx, "EVAL", `return redis.call("GET", KEYS[1]) .. ARGV[1]`, 1, "a", "a"))).To(Equal("1a"))
+	})
+
+	It("should prefix the keys of pipelines", func() {
+		pipeline := service.Pipeline()
+		set := pipeline.Set("a", "1", 0)
+		get := pipeline.Get("a")
+		Expect(pipeline.Exec(ctx)).To(Succeed())
+		Expect(set.Err()).ToNot(HaveOccurred())
+		Expect(get.Val()).To(Equal("1"))
+		Expect(raw.Get(ctx, "tenant:a")).To(Equal("1"))
+	})
+
+	It("should strip the namespace from SCAN and KEYS", func() {
+		Expect(raw.Set(ctx, "a", "other", 0)).To(Succeed())
+		Expect(service.Set(ctx, "a", "1", 0)).To(Succeed())
+		Expect(service.Set(ctx, "b", "2", 0)).To(Succeed())
+
+		keys, err := redis.Strings(service.Do(ctx, "KEYS", "*"))
+		Expect(err).ToNot(HaveOccurred())
+		sort.Strings(keys)
+		Expect(keys).To(Equal([]string{"a", "b"}))
+
+		var scanned []string
+		cursor := int64(0)
+		for {
+			values, err := redis.Values(service.Do(ctx, "SCAN", cursor))
+			Expect(err).ToNot(HaveOccurred())
+			var batch []string
+			_, err = redis.Scan(values, &cursor, &batch)
+			Expect(err).ToNot(HaveOccurred())
+			scanned = append(scanned, batch...)
+			if cursor == 0 {
+				break
+			}
+		}
+		sort.Strings(scanned)
+		Expect(scanned).To(Equal([]string{"a", "b"}))
+	})
+
+	It("should strip the namespace from the streams read", func(done Done) {
+		_, err := service.StreamAdd(ctx, "stream", []byte("entry"), StreamAddOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(raw.Do(ctx, "XLEN", "tenant:stream")).To(Equal(int64(1)))
+
+		consumeCtx, cancel := context.WithCancel(ctx)
+		Expect(service.ConsumeStreams(consumeCtx, StreamConsumerOptions{
+			Group:    "group",
+			Consumer: "c1",
+			Start:    "0",
+		}, func(stream, id string, data []byte) error {
+			Expect(stream).To(Equal("stream"))
+			cancel()
+			return nil
+		}, "stream")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should prefix the channels", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(raw.Publish(ctx, "test-ns", []byte("other"))).To(Succeed())
+			Expect(service.Publish(ctx, "test-ns", []byte("tenant"))).To(Succeed())
+			return nil
+		}
+
+		Expect(service.Subscribe(ctx, onSubscribed, func(channel string, data []byte) error {
+			Expect(channel).To(Equal("test-ns"))
+			Expect(string(data)).To(Equal("tenant"))
+			cancel()
+			return nil
+		}, "test-ns")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should prefix the patterns", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(raw.Publish(ctx, "test-ns.1", []byte("other"))).To(Succeed())
+			Expect(service.Publish(ctx, "test-ns.1", []byte("tenant"))).To(Succeed())
+			return nil
+		}
+
+		Expect(service.PSubscribe(ctx, onSubscribed, func(pattern, channel string, data []byte) error {
+			Expect(pattern).To(Equal("test-ns.*"))
+			Expect(channel).To(Equal("test-ns.1"))
+			Expect(string(data)).To(Equal("tenant"))
+			cancel()
+			return nil
+		}, "test-ns.*")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should strip the namespace from the replies of transactions", func() {
+		Expect(service.Set(ctx, "a", "1", 0)).To(Succeed())
+
+		var keys *StringsResult
+		Expect(service.Transaction(ctx, nil, func(tx *Tx) error {
+			tx.Set("b", "2", 0)
+			keys = tx.Strings("KEYS", "*")
+			return nil
+		})).To(Succeed())
+		sort.Strings(keys.Val())
+		Expect(keys.Val()).To(Equal([]string{"a", "b"}))
+
+		conn, err := service.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		Expect(conn.Send("MULTI")).To(Succeed())
+		Expect(conn.Send("KEYS", "a")).To(Succeed())
+		Expect(conn.Send("EXEC")).To(Succeed())
+		replies, err := redis.Values(conn.Do(""))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replies).To(HaveLen(3))
+		execReplies, err := redis.Values(replies[2], nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redis.Strings(execReplies[0], nil)).To(Equal([]string{"a"}))
+	})
+
+	It("should prefix the keys of the commands with keys in variable positions", func() {
+		ns := namespace("tenant:")
+		for _, test := range []struct {
+			command  string
+			args     []interface{}
+			expected []interface{}
+		}{
+			{"OBJECT", []interface{}{"ENCODING", "a"}, []interface{}{"ENCODING", "tenant:a"}},
+			{"MEMORY", []interface{}{"USAGE", "a", "SAMPLES", 0}, []interface{}{"USAGE", "tenant:a", "SAMPLES", 0}},
+			{"MEMORY", []interface{}{"STATS"}, []interface{}{"STATS"}},
+			{"LMPOP", []interface{}{2, "a", "b", "LEFT"}, []interface{}{2, "tenant:a", "tenant:b", "LEFT"}},
+			{"BLMPOP", []interface{}{0, 1, "a", "LEFT"}, []interface{}{0, 1, "tenant:a", "LEFT"}},
+			{"SINTERCARD", []interface{}{2, "a", "b", "LIMIT", 1}, []interface{}{2, "tenant:a", "tenant:b", "LIMIT", 1}},
+			{"ZRANGESTORE", []interface{}{"a", "b", 0, -1}, []interface{}{"tenant:a", "tenant:b", 0, -1}},
+			{"GEOSEARCHSTORE", []interface{}{"a", "b", "FROMMEMBER", "m"}, []interface{}{"tenant:a", "tenant:b", "FROMMEMBER", "m"}},
+			{"GEORADIUS", []interface{}{"a", 1, 2, 3, "km", "STORE", "b", "STOREDIST", "c"}, []interface{}{"tenant:a", 1, 2, 3, "km", "STORE", "tenant:b", "STOREDIST", "tenant:c"}},
+			{"GEORADIUSBYMEMBER", []interface{}{"a", "m", 3, "km", "STORE", "b"}, []interface{}{"tenant:a", "m", 3, "km", "STORE", "tenant:b"}},
+			{"SORT", []interface{}{"a", "BY", "w_*", "GET", "#", "GET", "v_*->f"}, []interface{}{"tenant:a", "BY", "tenant:w_*", "GET", "#", "GET", "tenant:v_*->f"}},
+			{"SORT", []interface{}{"a", "BY", "w_*", "STORE", "b"}, []interface{}{"tenant:a", "BY", "tenant:w_*", "STORE", "tenant:b"}},
+			{"PUBSUB", []interface{}{"NUMSUB", "a"}, []interface{}{"NUMSUB", "tenant:a"}},
+			{"PUBSUB", []interface{}{"CHANNELS"}, []interface{}{"CHANNELS", "tenant:*"}},
+		} {
+			Expect(ns.args(test.command, test.args)).To(Equal(test.expected), test.command)
+		}
+		Expect(ns.reply("LMPOP", []interface{}{[]byte("tenant:a"), []interface{}{[]byte("1")}})).To(Equal([]interface{}{[]byte("a"), []interface{}{[]byte("1")}}))
+	})
+
+	It("should reject the commands with unknown keys", func() {
+		_, err := service.Do(ctx, "FLUSHDB")
+		Expect(err).To(Equal(ErrNamespaceUnsupported))
+		_, err = service.Do(ctx, "MIGRATE", "localhost", 6380, "a", 0, 1000)
+		Expect(err).To(Equal(ErrNamespaceUnsupported))
+		Expect(raw.Do(ctx, "ECHO", "still here")).To(Equal([]byte("still here")))
+	})
+})
@@ -0,0 +1,369 @@
+package redigosrv
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nearCacheInvalidationChannel is the channel where Redis publishes the
+// invalidation messages when tracking is redirected (RESP2).
+const nearCacheInvalidationChannel = "__redis__:invalidate"
+
+// NearCacheOptions are the options of a `NearCache`.
+type NearCacheOptions struct {
+	// Size is the maximum number of entries kept in memory. Defaults to
+	// 10000.
+	Size int
+	// TTL is the maximum time an entry is kept in memory. If zero, entries
+	// are kept until invalidated or evicted.
+	TTL time.Duration
+	// Codec serializes the values, defaults to the codec of the service.
+	Codec Codec
+}
+
+type nearCacheEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+// NearCache is an in-process LRU in front of Redis. It is kept coherent using
+// the client side caching of Redis 6 (CLIENT TRACKING in broadcasting mode):
+// whenever a key of the cache changes in Redis, it is removed from memory.
+// While the tracking connection is down, the memory is flushed and every read
+// goes to Redis.
+type NearCache struct {
+	service *RedigoService
+	name    string
+	opts    NearCacheOptions
+	flights flightGroup
+
+	mu        sync.Mutex
+	tracking  bool
+	entries   map[string]*list.Element
+	lru       *list.List
+	inflight  map[string]bool
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewNearCache returns a new `NearCache` and starts tracking its keys. The
+// name is used as the prefix of the keys and as the label of the metrics.
+// It must be closed with `Close`.
+func (service *RedigoService) NewNearCache(name string, opts NearCacheOptions) *NearCache {
+	if opts.Size == 0 {
+		opts.Size = 10000
+	}
+	cache := &NearCache{
+		service:  service,
+		name:     name,
+		opts:     opts,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	cache.wg.Add(1)
+	go cache.run()
+	return cache
+}
+
+func (cache *NearCache) key(key string) string {
+	return cache.name + ":" + key
+}
+
+// Get reads a value into dst, from memory if possible. It returns `ErrNil`
+// when the key does not exist.
+func (cache *NearCache) Get(ctx context.Context, key string, dst interface{}) error {
+	key = cache.key(key)
+	if data, ok := cache.getLocal(key); ok {
+		cache.service.Collector.nearCacheHits.With(prometheus.Labels{"cache": cache.name}).Inc()
+		return cache.service.Unmarshal(cache.opts.Codec, data, dst)
+	}
+
+	data, err := cache.flights.do(ctx, key, func() ([]byte, error) {
+		return cache.fetch(detachedContext{ctx}, key)
+	})
+	if err != nil {
+		return err
+	}
+	return cache.service.Unmarshal(cache.opts.Codec, data, dst)
+}
+
+// Set writes a value to Redis. The value is not kept in memory, as the write
+// invalidates the key.
+func (cache *NearCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := cache.service.Marshal(cache.opts.Codec, value)
+	if err != nil {
+		return err
+	}
+	return cache.service.Set(ctx, cache.key(key), data, ttl)
+}
+
+// Delete removes values from Redis.
+func (cache *NearCache) Delete(ctx context.Context, keys ...string) error {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = cache.key(key)
+	}
+	_, err := cache.service.Del(ctx, cacheKeys...)
+	return err
+}
+
+// Len returns the number of entries kept in memory.
+func (cache *NearCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.lru.Len()
+}
+
+// isTracking checks if the keys are being tracked, so values can be kept in
+// memory.
+func (cache *NearCache) isTracking() bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.tracking
+}
+
+// Close stops tracking the keys and flushes the memory.
+func (cache *NearCache) Close() error {
+	cache.closeOnce.Do(func() {
+		close(cache.done)
+	})
+	cache.wg.Wait()
+	return nil
+}
+
+func (cache *NearCache) getLocal(key string) ([]byte, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.tracking {
+		return nil, false
+	}
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*nearCacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		cache.removeElement(element)
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+	return entry.data, true
+}
+
+// fetch reads the key from Redis and keeps it in memory, unless it was
+// invalidated while being read.
+func (cache *NearCache) fetch(ctx context.Context, key string) ([]byte, error) {
+	cache.mu.Lock()
+	cache.inflight[key] = false
+	cache.mu.Unlock()
+
+	data, err := cache.service.GetBytes(ctx, key)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	invalidated := cache.inflight[key]
+	delete(cache.inflight, key)
+	if err == nil && cache.tracking && !invalidated {
+		cache.store(key, data)
+	}
+	return data, err
+}
+
+// store must be called with the lock held.
+func (cache *NearCache) store(key string, data []byte) {
+	entry := &nearCacheEntry{
+		key:  key,
+		data: data,
+	}
+	if cache.opts.TTL > 0 {
+		entry.expiresAt = time.Now().Add(cache.opts.TTL)
+	}
+	if element, ok := cache.entries[key]; ok {
+		element.Value = entry
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.opts.Size {
+		cache.removeElement(cache.lru.Back())
+	}
+}
+
+// removeElement must be called with the lock held.
+func (cache *NearCache) removeElement(element *list.Element) {
+	cache.lru.Remove(element)
+	delete(cache.entries, element.Value.(*nearCacheEntry).key)
+}
+
+// invalidate removes the keys from memory.
+func (cache *NearCache) invalidate(keys []string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := cache.entries[key]; ok {
+			cache.removeElement(element)
+		}
+		if _, ok := cache.inflight[key]; ok {
+			cache.inflight[key] = true
+		}
+	}
+	cache.service.Collector.nearCacheInvalidated.With(prometheus.Labels{"cache": cache.name}).Add(float64(len(keys)))
+}
+
+// flush removes all entries from memory and sets if the keys are being
+// tracked.
+func (cache *NearCache) flush(tracking bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.tracking = tracking
+	cache.entries = make(map[string]*list.Element)
+	cache.lru.Init()
+	for key := range cache.inflight {
+		cache.inflight[key] = true
+	}
+}
+
+// run keeps tracking the keys, reconnecting with backoff when the tracking
+// connections fail.
+func (cache *NearCache) run() {
+	defer cache.wg.Done()
+
+	backoff := newBackoff(100*time.Millisecond, 5*time.Second)
+	for {
+		err := cache.track(backoff.reset)
+		cache.flush(false)
+
+		select {
+		case <-cache.done:
+			return
+		default:
+		}
+
+		cache.service.logger().Printf("redigosrv: near cache %s lost tracking: %s", cache.name, err)
+		select {
+		case <-time.After(backoff.next()):
+		case <-cache.done:
+			return
+		}
+	}
+}
+
+// track dials the invalidation connection, subscribed to the invalidation
+// channel, and the tracking connection, which redirects the invalidations of
+// the keys of the cache to the first one. It blocks until any of them fails
+// or the cache is closed.
+func (cache *NearCache) track(connected func()) error {
+	config := cache.service.Configuration
+	dial := func() (redis.Conn, error) {
+		return redis.Dial("tcp", config.Address,
+			redis.DialReadTimeout(config.PubSub.ReadTimeout),
+			redis.DialWriteTimeout(config.PubSub.WriteTimeout),
+		)
+	}
+
+	invalidations, err := dial()
+	if err != nil {
+		return err
+	}
+	defer invalidations.Close()
+
+	id, err := redis.Int64(invalidations.Do("CLIENT", "ID"))
+	if err != nil {
+		return err
+	}
+	if err := invalidations.Send("SUBSCRIBE", nearCacheInvalidationChannel); err != nil {
+		return err
+	}
+	if err := invalidations.Flush(); err != nil {
+		return err
+	}
+
+	tracking, err := dial()
+	if err != nil {
+		return err
+	}
+	defer tracking.Close()
+
+	prefix := cache.service.namespace().key(cache.key(""))
+	if _, err := tracking.Do("CLIENT", "TRACKING", "ON", "REDIRECT", id, "BCAST", "PREFIX", prefix); err != nil {
+		return err
+	}
+
+	// Checks the health of both connections, closing the invalidation
+	// connection to stop the receiving loop when any of them fails or the
+	// cache is closed.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		ticker := time.NewTicker(config.PubSub.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := tracking.Do("PING")
+				if err == nil {
+					err = invalidations.Send("PING")
+				}
+				if err == nil {
+					err = invalidations.Flush()
+				}
+				if err != nil {
+					invalidations.Close()
+					return
+				}
+			case <-cache.done:
+				invalidations.Close()
+				return
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	for {
+		reply, err := redis.Values(invalidations.Receive())
+		if err != nil {
+			return err
+		}
+		if len(reply) < 2 {
+			continue
+		}
+		kind, _ := redis.String(reply[0], nil)
+		switch kind {
+		case "subscribe":
+			cache.flush(true)
+			connected()
+		case "message":
+			if len(reply) < 3 {
+				continue
+			}
+			if reply[2] == nil {
+				// A nil message is sent when the database is flushed.
+				cache.flush(true)
+				continue
+			}
+			keys, err := redis.Strings(reply[2], nil)
+			if err != nil {
+				return errors.New("unexpected invalidation message")
+			}
+			ns := cache.service.namespace()
+			for i, key := range keys {
+				keys[i] = ns.strip(key)
+			}
+			cache.invalidate(keys)
+		}
+	}
+}
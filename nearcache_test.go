@@ -0,0 +1,119 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("NearCache", func() {
+	var service RedigoService
+	var cache *NearCache
+	ctx := context.Background()
+
+	invalidations := func() float64 {
+		var metric dto.Metric
+		Expect(service.Collector.nearCacheInvalidated.With(prometheus.Labels{"cache": "near"}).Write(&metric)).To(Succeed())
+		return metric.GetCounter().GetValue()
+	}
+
+	// set writes the key waiting for its invalidation, so it does not race
+	// with the reads that follow.
+	set := func(key string, value interface{}) {
+		before := invalidations()
+		Expect(cache.Set(ctx, key, value, 0)).To(Succeed())
+		Eventually(invalidations).Should(BeNumerically(">", before))
+	}
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "near:1", "near:2", "near:3")
+		Expect(err).ToNot(HaveOccurred())
+
+		cache = service.NewNearCache("near", NearCacheOptions{
+			Size: 2,
+		})
+		Eventually(cache.isTracking).Should(BeTrue())
+	})
+
+	AfterEach(func() {
+		cache.Close()
+		service.Stop()
+	})
+
+	It("should read from memory after the first read", func() {
+		set("1", "value")
+
+		var value string
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(value).To(Equal("value"))
+		Expect(cache.Len()).To(Equal(1))
+
+		value = ""
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(value).To(Equal("value"))
+
+		var metric dto.Metric
+		Expect(service.Collector.nearCacheHits.With(prometheus.Labels{"cache": "near"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should invalidate the memory when the key changes", func() {
+		set("1", "value")
+
+		var value string
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(cache.Len()).To(Equal(1))
+
+		before := invalidations()
+		Expect(service.Set(ctx, "near:1", `"changed"`, 0)).To(Succeed())
+		Eventually(cache.Len).Should(Equal(0))
+		Expect(invalidations()).To(BeNumerically(">", before))
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(value).To(Equal("changed"))
+	})
+
+	It("should evict the least recently used entries", func() {
+		for _, key := range []string{"1", "2", "3"} {
+			set(key, key)
+		}
+
+		var value string
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(cache.Get(ctx, "2", &value)).To(Succeed())
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(cache.Get(ctx, "3", &value)).To(Succeed())
+		Expect(cache.Len()).To(Equal(2))
+		data, ok := cache.getLocal("near:1")
+		Expect(ok).To(BeTrue())
+		Expect(data).To(Equal([]byte(`"1"`)))
+		_, ok = cache.getLocal("near:2")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should not keep missing keys", func() {
+		var value string
+		Expect(cache.Get(ctx, "1", &value)).To(Equal(ErrNil))
+		Expect(cache.Len()).To(Equal(0))
+	})
+
+	It("should flush the memory when the tracking connection drops", func() {
+		set("1", "value")
+
+		var value string
+		Expect(cache.Get(ctx, "1", &value)).To(Succeed())
+		Expect(cache.Len()).To(Equal(1))
+
+		_, err := service.Do(ctx, "CLIENT", "KILL", "TYPE", "pubsub")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(cache.Len).Should(Equal(0))
+		Eventually(cache.isTracking, time.Second).Should(BeTrue())
+	})
+})
@@ -0,0 +1,353 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrPipelineNotExecuted is returned by the results of a pipeline that was
+// not executed yet.
+var ErrPipelineNotExecuted = errors.New("pipeline not executed")
+
+// PipelineConfiguration is the configuration for pipelines.
+type PipelineConfiguration struct {
+	// ChunkSize is the maximum number of commands sent before reading the
+	// replies. Huge pipelines are split in chunks of this size.
+	ChunkSize int `yaml:"chunk_size"`
+}
+
+// commandQueue queues commands returning results to be resolved later. It is
+// shared by `Pipeline` and `Tx`.
+type commandQueue struct {
+	commands []*pipelineCommand
+}
+
+// Pipeline queues commands to be sent at once by `Exec`. Each queued command
+// returns a result that is resolved after `Exec`.
+type Pipeline struct {
+	commandQueue
+	service *RedigoService
+}
+
+type pipelineCommand struct {
+	name   string
+	args   []interface{}
+	result pipelineResult
+}
+
+type pipelineResult interface {
+	resolve(reply interface{}, err error)
+	Err() error
+}
+
+type baseResult struct {
+	err error
+}
+
+// Err returns the error of the command.
+func (result *baseResult) Err() error {
+	return result.err
+}
+
+// ValueResult is the result of a command with an untyped reply.
+type ValueResult struct {
+	baseResult
+	val interface{}
+}
+
+func (result *ValueResult) resolve(reply interface{}, err error) {
+	result.val, result.err = reply, err
+}
+
+// Val returns the reply of the command.
+func (result *ValueResult) Val() interface{} {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *ValueResult) Result() (interface{}, error) {
+	return result.val, result.err
+}
+
+// StringResult is the result of a command with a string reply.
+type StringResult struct {
+	baseResult
+	val string
+}
+
+func (result *StringResult) resolve(reply interface{}, err error) {
+	result.val, result.err = redis.String(reply, err)
+}
+
+// Val returns the reply of the command.
+func (result *StringResult) Val() string {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *StringResult) Result() (string, error) {
+	return result.val, result.err
+}
+
+// IntResult is the result of a command with an integer reply.
+type IntResult struct {
+	baseResult
+	val int64
+}
+
+func (result *IntResult) resolve(reply interface{}, err error) {
+	result.val, result.err = redis.Int64(reply, err)
+}
+
+// Val returns the reply of the command.
+func (result *IntResult) Val() int64 {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *IntResult) Result() (int64, error) {
+	return result.val, result.err
+}
+
+// FloatResult is the result of a command with a float reply.
+type FloatResult struct {
+	baseResult
+	val float64
+}
+
+func (result *FloatResult) resolve(reply interface{}, err error) {
+	result.val, result.err = redis.Float64(reply, err)
+}
+
+// Val returns the reply of the command.
+func (result *FloatResult) Val() float64 {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *FloatResult) Result() (float64, error) {
+	return result.val, result.err
+}
+
+// BoolResult is the result of a command with a boolean reply.
+type BoolResult struct {
+	baseResult
+	val bool
+}
+
+func (result *BoolResult) resolve(reply interface{}, err error) {
+	result.val, result.err = redis.Bool(reply, err)
+}
+
+// Val returns the reply of the command.
+func (result *BoolResult) Val() bool {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *BoolResult) Result() (bool, error) {
+	return result.val, result.err
+}
+
+// StringsResult is the result of a command with a list of strings reply.
+type StringsResult struct {
+	baseResult
+	val []string
+}
+
+func (result *StringsResult) resolve(reply interface{}, err error) {
+	result.val, result.err = redis.Strings(reply, err)
+}
+
+// Val returns the reply of the command.
+func (result *StringsResult) Val() []string {
+	return result.val
+}
+
+// Result returns the reply and the error of the command.
+func (result *StringsResult) Result() ([]string, error) {
+	return result.val, result.err
+}
+
+// Pipeline creates a new pipeline.
+func (service *RedigoService) Pipeline() *Pipeline {
+	return &Pipeline{
+		service: service,
+	}
+}
+
+func (queue *commandQueue) queue(result pipelineResult, commandName string, args []interface{}) {
+	result.resolve(nil, ErrPipelineNotExecuted)
+	queue.commands = append(queue.commands, &pipelineCommand{
+		name:   commandName,
+		args:   args,
+		result: result,
+	})
+}
+
+// Len returns the number of queued commands.
+func (queue *commandQueue) Len() int {
+	return len(queue.commands)
+}
+
+// Do queues a command with an untyped reply.
+func (queue *commandQueue) Do(commandName string, args ...interface{}) *ValueResult {
+	result := &ValueResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// String queues a command with a string reply.
+func (queue *commandQueue) String(commandName string, args ...interface{}) *StringResult {
+	result := &StringResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// Int queues a command with an integer reply.
+func (queue *commandQueue) Int(commandName string, args ...interface{}) *IntResult {
+	result := &IntResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// Float queues a command with a float reply.
+func (queue *commandQueue) Float(commandName string, args ...interface{}) *FloatResult {
+	result := &FloatResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// Bool queues a command with a boolean reply.
+func (queue *commandQueue) Bool(commandName string, args ...interface{}) *BoolResult {
+	result := &BoolResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// Strings queues a command with a list of strings reply.
+func (queue *commandQueue) Strings(commandName string, args ...interface{}) *StringsResult {
+	result := &StringsResult{}
+	queue.queue(result, commandName, args)
+	return result
+}
+
+// Get queues a GET.
+func (queue *commandQueue) Get(key string) *StringResult {
+	return queue.String("GET", key)
+}
+
+// Set queues a SET. If the ttl is positive, the key expires after it.
+func (queue *commandQueue) Set(key string, value interface{}, ttl time.Duration) *StringResult {
+	return queue.String("SET", expirationArgs(redis.Args{key, value}, ttl)...)
+}
+
+// Incr queues an INCR.
+func (queue *commandQueue) Incr(key string) *IntResult {
+	return queue.Int("INCR", key)
+}
+
+// IncrBy queues an INCRBY.
+func (queue *commandQueue) IncrBy(key string, increment int64) *IntResult {
+	return queue.Int("INCRBY", key, increment)
+}
+
+// Del queues a DEL.
+func (queue *commandQueue) Del(keys ...string) *IntResult {
+	return queue.Int("DEL", redis.Args{}.AddFlat(keys)...)
+}
+
+// Expire queues a PEXPIRE.
+func (queue *commandQueue) Expire(key string, ttl time.Duration) *BoolResult {
+	return queue.Bool("PEXPIRE", key, int64(ttl/time.Millisecond))
+}
+
+// HGet queues a HGET.
+func (queue *commandQueue) HGet(key, field string) *StringResult {
+	return queue.String("HGET", key, field)
+}
+
+// HSet queues a HSET.
+func (queue *commandQueue) HSet(key, field string, value interface{}) *BoolResult {
+	return queue.Bool("HSET", key, field, value)
+}
+
+// Exec sends all queued commands and resolves their results. Large pipelines
+// are sent in chunks (see `PipelineConfiguration`). It returns the first
+// error found, ignoring `ErrNil`; the error of each command is available on
+// its result. The pipeline is emptied and can be reused.
+func (pipeline *Pipeline) Exec(ctx context.Context) error {
+	commands := pipeline.commands
+	pipeline.commands = nil
+	if len(commands) == 0 {
+		return nil
+	}
+
+	chunkSize := pipeline.service.Configuration.Pipeline.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(commands)
+	}
+	var firstErr error
+	err := pipeline.service.RunWithConnContext(ctx, func(conn redis.ConnWithTimeout) error {
+		for start := 0; start < len(commands); start += chunkSize {
+			end := start + chunkSize
+			if end > len(commands) {
+				end = len(commands)
+			}
+			if err := execChunk(ctx, conn, commands[start:end], &firstErr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, command := range commands {
+			if command.result.Err() == ErrPipelineNotExecuted {
+				command.result.resolve(nil, err)
+			}
+		}
+		return err
+	}
+	return firstErr
+}
+
+// execChunk sends the commands and reads their replies. It returns an error
+// only when the connection failed, command errors are set to their results.
+func execChunk(ctx context.Context, conn redis.ConnWithTimeout, commands []*pipelineCommand, firstErr *error) error {
+	for _, command := range commands {
+		if err := conn.Send(command.name, command.args...); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for _, command := range commands {
+		reply, err := receiveContext(ctx, conn)
+		if err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return err
+			}
+		}
+		command.result.resolve(reply, err)
+		if err := command.result.Err(); *firstErr == nil && err != nil && err != ErrNil {
+			*firstErr = err
+		}
+	}
+	return nil
+}
+
+// receiveContext receives a reply honoring the deadline of the context.
+func receiveContext(ctx context.Context, conn redis.ConnWithTimeout) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		return conn.ReceiveWithTimeout(timeout)
+	}
+	return conn.Receive()
+}
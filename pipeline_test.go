@@ -0,0 +1,93 @@
+package redigosrv
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Pipeline)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Pipeline: PipelineConfiguration{
+				ChunkSize: 3,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "pipeline-string", "pipeline-hash", "pipeline-counter")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should resolve the results after exec", func() {
+		pipeline := service.Pipeline()
+		set := pipeline.Set("pipeline-string", "value", 0)
+		get := pipeline.Get("pipeline-string")
+		missing := pipeline.HGet("pipeline-hash", "field")
+		incr := pipeline.Incr("pipeline-counter")
+		exists := pipeline.Bool("EXISTS", "pipeline-string")
+
+		Expect(get.Err()).To(Equal(ErrPipelineNotExecuted))
+		Expect(pipeline.Len()).To(Equal(5))
+		Expect(pipeline.Exec(ctx)).To(Succeed())
+		Expect(pipeline.Len()).To(Equal(0))
+
+		Expect(set.Result()).To(Equal("OK"))
+		Expect(get.Result()).To(Equal("value"))
+		Expect(missing.Err()).To(Equal(ErrNil))
+		Expect(incr.Val()).To(Equal(int64(1)))
+		Expect(exists.Val()).To(BeTrue())
+	})
+
+	It("should report errors per command", func() {
+		pipeline := service.Pipeline()
+		set := pipeline.Set("pipeline-string", "value", 0)
+		incr := pipeline.Incr("pipeline-string")
+		get := pipeline.Get("pipeline-string")
+
+		err := pipeline.Exec(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(err).To(Equal(incr.Err()))
+		Expect(set.Err()).ToNot(HaveOccurred())
+		Expect(get.Val()).To(Equal("value"))
+	})
+
+	It("should split huge pipelines in chunks", func() {
+		pipeline := service.Pipeline()
+		results := make([]*IntResult, 10)
+		for i := range results {
+			results[i] = pipeline.Incr("pipeline-counter")
+		}
+		Expect(pipeline.Exec(ctx)).To(Succeed())
+		for i, result := range results {
+			Expect(result.Result()).To(Equal(int64(i+1)), fmt.Sprintf("result %d", i))
+		}
+
+		var metric dto.Metric
+		Expect(service.Collector.commandCalls.With(prometheus.Labels{
+			"method":  "Send",
+			"command": "INCR",
+		}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(BeNumerically(">=", 10))
+	})
+
+	It("should fail all results when the service is not running", func() {
+		var stopped RedigoService
+		pipeline := stopped.Pipeline()
+		get := pipeline.Get("pipeline-string")
+		Expect(pipeline.Exec(ctx)).To(HaveOccurred())
+		Expect(get.Err()).To(HaveOccurred())
+		Expect(get.Err()).ToNot(Equal(ErrPipelineNotExecuted))
+	})
+})
@@ -2,49 +2,165 @@ package redigosrv
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
+// ErrDurablePatterns is returned when subscribing to patterns in durable
+// mode.
+var ErrDurablePatterns = errors.New("pattern subscriptions are not durable")
+
+// durableStreamPrefix prefixes the streams backing the channels in durable
+// mode.
+const durableStreamPrefix = "pubsub:"
+
+// DurablePubSubConfiguration is the configuration of the durable mode of
+// `Publish` and `Subscribe`. Each channel is backed by the stream
+// `pubsub:<channel>`, and each subscriber by a consumer group, so a
+// subscriber that was offline catches up from its last acknowledged message.
+type DurablePubSubConfiguration struct {
+	Enabled bool `yaml:"enabled"`
+	// Subscriber names the consumer group of the subscriptions. Required to
+	// subscribe.
+	Subscriber string `yaml:"subscriber"`
+	// Consumer names this instance in the group, so instances with the same
+	// subscriber share the messages. Defaults to the subscriber.
+	Consumer string `yaml:"consumer"`
+	// MaxLen approximately trims the streams to this number of messages, if
+	// positive. Messages trimmed before a subscriber reads them are lost.
+	MaxLen int64 `yaml:"max_len"`
+}
+
 // SubscriptionHandler is called for each new message.
 type SubscriptionHandler func(channel string, data []byte) error
 
+// PatternSubscriptionHandler is called for each new message of the channels
+// matching a pattern, with the pattern matched.
+type PatternSubscriptionHandler func(pattern, channel string, data []byte) error
+
 // SubscribedHandler it called when all channels are subscribed.
 type SubscribedHandler func() error
 
-// Publish sends a data payload to a specific channel.
+// Message is a message received from a channel.
+type Message struct {
+	Channel string
+	// Data is the payload, already decrypted and decompressed.
+	Data    []byte
+	service *RedigoService
+	codec   Codec
+}
+
+// Decode deserializes the message into v using its content-type marker or,
+// if not marked, the codec of the subscription.
+func (msg *Message) Decode(v interface{}) error {
+	return msg.service.decode(msg.codec, msg.Data, v)
+}
+
+// MessageHandler is called for each new message.
+type MessageHandler func(msg *Message) error
+
+// Publish sends a data payload to a specific channel. Byte slices are sent
+// as they are, any other value is serialized using the configured codec.
+// Both are compressed and encrypted when enabled.
 func (service *RedigoService) Publish(ctx context.Context, channel string, data interface{}) error {
+	return service.PublishWithCodec(ctx, channel, data, nil)
+}
+
+// PublishWithCodec sends a data payload to a specific channel serializing it
+// with the given codec, or the configured one if nil. Byte slices are not
+// serialized.
+func (service *RedigoService) PublishWithCodec(ctx context.Context, channel string, data interface{}, codec Codec) error {
 
 	counter := service.Collector.publishTrafficSize
 
 	return service.RunWithConn(func(conn redis.ConnWithTimeout) error {
 		var message []byte
-		switch t := data.(type) {
-		case []byte:
-			// Increment publishTrafficSize with the size of message
-			counter.Add(float64(len(t)))
-			message = t
-		default:
-			m, err := json.Marshal(t)
-			if err != nil {
-				return err
-			}
-			// Increment publishTrafficSize with the size of message
-			counter.Add(float64(len(m)))
-			message = m
+		var err error
+		if raw, ok := data.([]byte); ok {
+			message, err = service.wrap(raw)
+		} else {
+			message, err = service.Marshal(codec, data)
+		}
+		if err != nil {
+			return err
 		}
 
-		_, err := conn.Do("PUBLISH", channel, message)
+		// Increment publishTrafficSize with the size of message sent
+		counter.Add(float64(len(message)))
+
+		if durable := service.Configuration.PubSub.Durable; durable.Enabled {
+			_, err = conn.Do("XADD", streamAddArgs(durableStreamPrefix+channel, message, StreamAddOptions{
+				MaxLen:      durable.MaxLen,
+				Approximate: true,
+			})...)
+			return err
+		}
+
+		_, err = conn.Do("PUBLISH", channel, message)
 		return err
 	})
 }
 
+// SubscribeMessages works as `Subscribe`, but the handler receives messages
+// that can be decoded using the given codec, or the configured one if nil.
+func (service *RedigoService) SubscribeMessages(ctx context.Context, codec Codec, subscribed SubscribedHandler, handler MessageHandler, channels ...string) error {
+	return service.Subscribe(ctx, subscribed, func(channel string, data []byte) error {
+		return handler(&Message{
+			Channel: channel,
+			Data:    data,
+			service: service,
+			codec:   codec,
+		})
+	}, channels...)
+}
+
 // Subscribe listens for messages on Redis pubsub channels. The
 // subscribed function is called after the channels are subscribed. The subscription
-// function is called for each message.
+// function is called for each message, decrypted and decompressed if needed.
+//
+// In durable mode, the messages are read from streams instead, and
+// acknowledged when the subscription function returns no error.
 func (service *RedigoService) Subscribe(ctx context.Context, subscribed SubscribedHandler, subscription SubscriptionHandler, channels ...string) error {
+	if service.Configuration.PubSub.Durable.Enabled {
+		return unwrapConnectionLost(service.subscribeDurable(ctx, subscribed, subscription, channels...))
+	}
+	return unwrapConnectionLost(service.subscribe(ctx, subscribed, func(pattern, channel string, data []byte) error {
+		return subscription(channel, data)
+	}, channels, nil))
+}
+
+// PSubscribe listens for messages on the Redis pubsub channels matching the
+// glob-style patterns, as `Subscribe` does. The subscription function
+// receives the pattern matched and the channel of each message. It is not
+// supported in durable mode.
+func (service *RedigoService) PSubscribe(ctx context.Context, subscribed SubscribedHandler, subscription PatternSubscriptionHandler, patterns ...string) error {
+	if service.Configuration.PubSub.Durable.Enabled {
+		return ErrDurablePatterns
+	}
+	return unwrapConnectionLost(service.subscribe(ctx, subscribed, subscription, nil, patterns))
+}
+
+// subscriptionCount returns the number of subscriptions of a connection
+// subscribed to the channels and patterns, which are counted once each.
+func subscriptionCount(channels, patterns []string) int {
+	unique := make(map[string]struct{}, len(channels)+len(patterns))
+	for _, channel := range channels {
+		unique["c:"+channel] = struct{}{}
+	}
+	for _, pattern := range patterns {
+		unique["p:"+pattern] = struct{}{}
+	}
+	return len(unique)
+}
+
+// subscribe listens for messages on both channels and patterns. The pattern
+// is empty for the messages of channels. Failures of the connection are
+// returned as `connectionLostError`.
+func (service *RedigoService) subscribe(ctx context.Context, subscribed SubscribedHandler, subscription PatternSubscriptionHandler, channels, patterns []string) error {
 
 	c, err := redis.Dial("tcp", service.Configuration.Address,
 		// Read timeout on server should be greater than ping period.
@@ -52,15 +168,42 @@ func (service *RedigoService) Subscribe(ctx context.Context, subscribed Subscrib
 		redis.DialWriteTimeout(service.Configuration.PubSub.WriteTimeout),
 	)
 	if err != nil {
-		return err
+		return &connectionLostError{err}
 	}
 	defer c.Close()
 
+	var active int32
+	defer func() {
+		if atomic.LoadInt32(&active) == 1 {
+			// Decrement 1 in subscriptionsActive
+			service.Collector.subscriptionsActive.Dec()
+		}
+	}()
+
+	ns := service.namespace()
 	psc := redis.PubSubConn{Conn: c}
-	if err := psc.Subscribe(redis.Args{}.AddFlat(channels)...); err != nil {
-		return err
+	if len(channels) > 0 {
+		args := redis.Args{}
+		for _, channel := range channels {
+			args = args.Add(ns.key(channel))
+		}
+		if err := psc.Subscribe(args...); err != nil {
+			return &connectionLostError{err}
+		}
+	}
+	if len(patterns) > 0 {
+		args := redis.Args{}
+		for _, pattern := range patterns {
+			args = args.Add(ns.key(pattern))
+		}
+		if err := psc.PSubscribe(args...); err != nil {
+			return &connectionLostError{err}
+		}
 	}
 
+	// The server replies with the count of both channels and patterns
+	// subscribed by the connection.
+	count := subscriptionCount(channels, patterns)
 	done := make(chan error, 1)
 
 	// Start a goroutine to receive notifications from the server.
@@ -71,27 +214,36 @@ func (service *RedigoService) Subscribe(ctx context.Context, subscribed Subscrib
 				// Increment to count failures
 				service.Collector.subscribeFailures.Inc()
 
-				done <- n
+				done <- &connectionLostError{n}
 				return
 			case redis.Message:
-				if err := subscription(n.Channel, n.Data); err != nil {
-
-					// Increment to count failures
-					service.Collector.subscribeFailures.Inc()
-
+				pattern := n.Pattern
+				if pattern != "" {
+					pattern = ns.strip(pattern)
+				}
+				channel := ns.strip(n.Channel)
+				data, unwrapErr := service.unwrap(n.Data)
+				if unwrapErr != nil {
+					data = n.Data
+				}
+				err := service.handleMessage(ctx, channel, data, func() error {
+					if unwrapErr != nil {
+						return unwrapErr
+					}
+					return subscription(pattern, channel, data)
+				})
+				if err != nil {
 					done <- err
 					return
 				}
 
-				// Increment to count success
-				service.Collector.subscribeSuccesses.Inc()
-
 			case redis.Subscription:
-				switch n.Count {
-				case len(channels):
+				switch {
+				case n.Count == count && (n.Kind == "subscribe" || n.Kind == "psubscribe"):
 
 					// Increment 1 in subscriptionsActive
 					service.Collector.subscriptionsActive.Inc()
+					atomic.StoreInt32(&active, 1)
 
 					// Notify application when all channels are subscribed.
 					if err := subscribed(); err != nil {
@@ -106,7 +258,7 @@ func (service *RedigoService) Subscribe(ctx context.Context, subscribed Subscrib
 					// Increment to count success
 					service.Collector.subscribeSuccesses.Inc()
 
-				case 0:
+				case n.Count == 0:
 					// Return from the goroutine when all channels are unsubscribed.
 					done <- nil
 					return
@@ -142,12 +294,73 @@ loop:
 		}
 	}
 
-	// Decrement 1 in subscriptionsActive
-	service.Collector.subscriptionsActive.Dec()
-
-	// Signal the receiving goroutine to exit by unsubscribing from all channels.
-	psc.Unsubscribe()
+	// Signal the receiving goroutine to exit by unsubscribing from all channels
+	// and patterns.
+	if len(channels) > 0 {
+		psc.Unsubscribe()
+	}
+	if len(patterns) > 0 {
+		psc.PUnsubscribe()
+	}
 
 	// Wait for goroutine to complete.
 	return <-done
 }
+
+// subscribeDurable consumes the streams backing the channels as the
+// configured subscriber. The errors not returned by the handlers are
+// returned as `connectionLostError`.
+func (service *RedigoService) subscribeDurable(ctx context.Context, subscribed SubscribedHandler, subscription SubscriptionHandler, channels ...string) error {
+	durable := service.Configuration.PubSub.Durable
+	if durable.Subscriber == "" || durable.Consumer == "" {
+		return ErrStreamConsumerRequired
+	}
+	streams := make([]string, len(channels))
+	for i, channel := range channels {
+		streams[i] = durableStreamPrefix + channel
+	}
+
+	active := false
+	defer func() {
+		if active {
+			// Decrement 1 in subscriptionsActive
+			service.Collector.subscriptionsActive.Dec()
+		}
+	}()
+
+	opts := StreamConsumerOptions{
+		Group:    durable.Subscriber,
+		Consumer: durable.Consumer,
+	}
+	var handlerErr error
+	err := service.consumeStreams(ctx, opts, func() error {
+		// Increment 1 in subscriptionsActive
+		service.Collector.subscriptionsActive.Inc()
+		active = true
+
+		if err := subscribed(); err != nil {
+			service.Collector.subscribeFailures.Inc()
+			handlerErr = err
+			return err
+		}
+		service.Collector.subscribeSuccesses.Inc()
+		return nil
+	}, func(stream, id string, raw []byte) error {
+		channel := strings.TrimPrefix(stream, durableStreamPrefix)
+		data, unwrapErr := service.unwrap(raw)
+		if unwrapErr != nil {
+			data = raw
+		}
+		handlerErr = service.handleMessage(ctx, channel, data, func() error {
+			if unwrapErr != nil {
+				return unwrapErr
+			}
+			return subscription(channel, data)
+		})
+		return handlerErr
+	}, true, streams...)
+	if err != nil && err != handlerErr {
+		return &connectionLostError{err}
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnknownOverflowPolicy is returned when the overflow policy is not one
+// of the `OverflowPolicy` constants.
+var ErrUnknownOverflowPolicy = errors.New("unknown overflow policy")
+
+// ErrNegativeBuffer is returned when the buffer of a `ChannelSubscription`
+// is negative.
+var ErrNegativeBuffer = errors.New("negative channel buffer")
+
+// OverflowPolicy is what a `ChannelSubscription` does with a message when
+// its buffer is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for the buffer, which stops receiving messages.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest drops the oldest message of the buffer.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest drops the message received.
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+)
+
+func (policy OverflowPolicy) valid() bool {
+	switch policy {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return true
+	}
+	return false
+}
+
+// ChannelConfiguration is the configuration of the subscriptions returned
+// by `SubscribeChan`.
+type ChannelConfiguration struct {
+	// Buffer is the size of the channel of messages. Defaults to 100, and
+	// it cannot be negative.
+	Buffer int `yaml:"buffer"`
+	// Overflow is the policy when the buffer is full. Defaults to "block".
+	Overflow OverflowPolicy `yaml:"overflow"`
+}
+
+// ChannelSubscription is a subscription whose messages are received from a
+// Go channel.
+type ChannelSubscription struct {
+	messages chan *Message
+	done     chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Messages returns the channel of messages, which is closed when the
+// subscription is over.
+func (sub *ChannelSubscription) Messages() <-chan *Message {
+	return sub.messages
+}
+
+// Err returns the error that ended the subscription, if any. It is nil while
+// the subscription runs and when it ends because the context is done.
+func (sub *ChannelSubscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Done returns a channel closed when the subscription is over.
+func (sub *ChannelSubscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// SubscribeChan subscribes to the channels, as `Subscribe` does, sending the
+// messages to a Go channel with the configured buffer and overflow policy.
+// It returns once the channels are subscribed or the subscription failed.
+// The subscription ends when the context is done.
+func (service *RedigoService) SubscribeChan(ctx context.Context, channels ...string) *ChannelSubscription {
+	return service.SubscribeChanWithOptions(ctx, service.Configuration.PubSub.Channel, channels...)
+}
+
+// SubscribeChanWithOptions works as `SubscribeChan` with the given buffer
+// and overflow policy. Zero values use the configured ones.
+func (service *RedigoService) SubscribeChanWithOptions(ctx context.Context, opts ChannelConfiguration, channels ...string) *ChannelSubscription {
+	if opts.Buffer == 0 {
+		opts.Buffer = service.Configuration.PubSub.Channel.Buffer
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = service.Configuration.PubSub.Channel.Overflow
+	}
+
+	var err error
+	switch {
+	case opts.Buffer < 0:
+		err = ErrNegativeBuffer
+		opts.Buffer = 0
+	case !opts.Overflow.valid():
+		err = ErrUnknownOverflowPolicy
+	}
+
+	sub := &ChannelSubscription{
+		messages: make(chan *Message, opts.Buffer),
+		done:     make(chan struct{}),
+	}
+	if err != nil {
+		sub.err = err
+		close(sub.messages)
+		close(sub.done)
+		return sub
+	}
+
+	subscribed := make(chan struct{})
+	go func() {
+		err := service.Subscribe(ctx, func() error {
+			close(subscribed)
+			return nil
+		}, func(channel string, data []byte) error {
+			sub.send(ctx, opts.Overflow, &Message{
+				Channel: channel,
+				Data:    data,
+				service: service,
+			}, service.Collector)
+			return nil
+		}, channels...)
+
+		sub.mu.Lock()
+		sub.err = err
+		sub.mu.Unlock()
+		close(sub.messages)
+		close(sub.done)
+	}()
+
+	select {
+	case <-subscribed:
+	case <-sub.done:
+	}
+	return sub
+}
+
+// send sends the message applying the overflow policy. Only the
+// subscription goroutine sends, so dropping the oldest message always
+// makes room.
+func (sub *ChannelSubscription) send(ctx context.Context, policy OverflowPolicy, msg *Message, collector *RedigoCollector) {
+	switch policy {
+	case OverflowBlock:
+		select {
+		case sub.messages <- msg:
+		case <-ctx.Done():
+		}
+	case OverflowDropNewest:
+		select {
+		case sub.messages <- msg:
+		default:
+			// Increment to count dropped messages
+			collector.subscribeDropped.Inc()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.messages <- msg:
+				return
+			default:
+			}
+			select {
+			case <-sub.messages:
+				// Increment to count dropped messages
+				collector.subscribeDropped.Inc()
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package redigosrv
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (SubscribeChan)", func() {
+	var service RedigoService
+	var subCtx context.Context
+	var cancel context.CancelFunc
+	var subs []*ChannelSubscription
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		subCtx, cancel = context.WithCancel(ctx)
+		subs = nil
+	})
+
+	AfterEach(func() {
+		// The subscriptions must be over before the service is started again.
+		cancel()
+		for _, sub := range subs {
+			Eventually(sub.Done()).Should(BeClosed())
+		}
+		service.Stop()
+	})
+
+	subscribe := func(opts ChannelConfiguration) *ChannelSubscription {
+		sub := service.SubscribeChanWithOptions(subCtx, opts, "test-chan")
+		subs = append(subs, sub)
+		return sub
+	}
+
+	dropped := func() float64 {
+		var metric dto.Metric
+		Expect(service.Collector.subscribeDropped.Write(&metric)).To(Succeed())
+		return metric.GetCounter().GetValue()
+	}
+
+	publish := func(n int) {
+		for i := 0; i < n; i++ {
+			Expect(service.Publish(ctx, "test-chan", []byte(fmt.Sprint(i)))).To(Succeed())
+		}
+	}
+
+	receive := func(sub *ChannelSubscription, n int) []string {
+		var data []string
+		for i := 0; i < n; i++ {
+			var msg *Message
+			Eventually(sub.Messages()).Should(Receive(&msg))
+			data = append(data, string(msg.Data))
+		}
+		return data
+	}
+
+	It("should receive the messages until the context is done", func() {
+		sub := service.SubscribeChan(subCtx, "test-chan")
+		subs = append(subs, sub)
+		Expect(sub.Err()).ToNot(HaveOccurred())
+
+		Expect(service.Publish(ctx, "test-chan", "hello")).To(Succeed())
+		var msg *Message
+		Eventually(sub.Messages()).Should(Receive(&msg))
+		Expect(msg.Channel).To(Equal("test-chan"))
+		var text string
+		Expect(msg.Decode(&text)).To(Succeed())
+		Expect(text).To(Equal("hello"))
+
+		cancel()
+		Eventually(sub.Done()).Should(BeClosed())
+		Expect(sub.Messages()).To(BeClosed())
+		Expect(sub.Err()).ToNot(HaveOccurred())
+	})
+
+	It("should drop the newest messages when the buffer is full", func() {
+		sub := subscribe(ChannelConfiguration{
+			Buffer:   2,
+			Overflow: OverflowDropNewest,
+		})
+
+		publish(5)
+		Eventually(dropped).Should(Equal(float64(3)))
+		Expect(receive(sub, 2)).To(Equal([]string{"0", "1"}))
+	})
+
+	It("should drop the oldest messages when the buffer is full", func() {
+		sub := subscribe(ChannelConfiguration{
+			Buffer:   2,
+			Overflow: OverflowDropOldest,
+		})
+
+		publish(5)
+		Eventually(dropped).Should(Equal(float64(3)))
+		Expect(receive(sub, 2)).To(Equal([]string{"3", "4"}))
+	})
+
+	It("should block when the buffer is full", func() {
+		sub := subscribe(ChannelConfiguration{
+			Buffer: 1,
+		})
+
+		publish(3)
+		Expect(receive(sub, 3)).To(Equal([]string{"0", "1", "2"}))
+		Expect(dropped()).To(Equal(float64(0)))
+	})
+
+	It("should fail with an unknown overflow policy", func() {
+		sub := subscribe(ChannelConfiguration{
+			Overflow: "unknown",
+		})
+		Expect(sub.Err()).To(Equal(ErrUnknownOverflowPolicy))
+		Expect(sub.Messages()).To(BeClosed())
+
+		var other RedigoService
+		Expect(other.ApplyConfiguration(Configuration{
+			PubSub: PubSubConfiguration{
+				Channel: ChannelConfiguration{Overflow: "unknown"},
+			},
+		})).To(Equal(ErrUnknownOverflowPolicy))
+	})
+
+	It("should fail with a negative buffer", func() {
+		sub := subscribe(ChannelConfiguration{
+			Buffer: -1,
+		})
+		Expect(sub.Err()).To(Equal(ErrNegativeBuffer))
+		Expect(sub.Messages()).To(BeClosed())
+
+		var other RedigoService
+		Expect(other.ApplyConfiguration(Configuration{
+			PubSub: PubSubConfiguration{
+				Channel: ChannelConfiguration{Buffer: -1},
+			},
+		})).To(Equal(ErrNegativeBuffer))
+	})
+})
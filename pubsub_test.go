@@ -57,6 +57,36 @@ var _ = Describe("RedigoService (PubSub)", func() {
 		close(done)
 	})
 
+	It("should subscribe and decode messages", func(done Done) {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Serialization: SerializationConfiguration{
+				ContentTypeMarker: true,
+			},
+		})).To(BeNil())
+		Expect(service.Start()).To(BeNil())
+		defer service.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(service.PublishWithCodec(ctx, "test-01", map[string]string{"hello": "msgpack"}, MsgPackCodec)).To(Succeed())
+			return nil
+		}
+
+		Expect(service.SubscribeMessages(ctx, nil, onSubscribed, func(msg *Message) error {
+			var value map[string]string
+			Expect(msg.Channel).To(Equal("test-01"))
+			Expect(msg.Decode(&value)).To(Succeed())
+			Expect(value).To(Equal(map[string]string{"hello": "msgpack"}))
+			cancel()
+			return nil
+		}, "test-01")).To(Succeed())
+
+		close(done)
+	})
+
 	It("should propagate error from subscribed handler", func(done Done) {
 		var service RedigoService
 		Expect(service.ApplyConfiguration(Configuration{
@@ -106,4 +136,136 @@ var _ = Describe("RedigoService (PubSub)", func() {
 
 		close(done)
 	})
+	It("should subscribe to patterns", func(done Done) {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(BeNil())
+		Expect(service.Start()).To(BeNil())
+		defer service.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		onSubscribed := func() error {
+			Expect(service.Publish(ctx, "orders.created", []byte("hello from pattern"))).To(Succeed())
+			return nil
+		}
+
+		Expect(service.PSubscribe(ctx, onSubscribed, func(pattern, channel string, data []byte) error {
+			Expect(pattern).To(Equal("orders.*"))
+			Expect(channel).To(Equal("orders.created"))
+			Expect(data).To(Equal([]byte("hello from pattern")))
+			cancel()
+			return nil
+		}, "orders.*")).To(Succeed())
+
+		close(done)
+	})
+
+	It("should count both channels and patterns subscribed", func(done Done) {
+		var service RedigoService
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(BeNil())
+		Expect(service.Start()).To(BeNil())
+		defer service.Stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		subscribed := 0
+		onSubscribed := func() error {
+			subscribed++
+			Expect(service.Publish(ctx, "test-01", []byte("channel"))).To(Succeed())
+			Expect(service.Publish(ctx, "orders.created", []byte("pattern"))).To(Succeed())
+			return nil
+		}
+
+		var received []string
+		Expect(service.subscribe(ctx, onSubscribed, func(pattern, channel string, data []byte) error {
+			received = append(received, pattern+"|"+channel+"|"+string(data))
+			if len(received) == 2 {
+				cancel()
+			}
+			return nil
+		}, []string{"test-01", "test-01", "test-02"}, []string{"orders.*"})).To(Succeed())
+
+		Expect(subscribed).To(Equal(1))
+		Expect(received).To(Equal([]string{"|test-01|channel", "orders.*|orders.created|pattern"}))
+
+		close(done)
+	})
+
+	Context("durable", func() {
+		var service RedigoService
+
+		BeforeEach(func() {
+			Expect(service.ApplyConfiguration(Configuration{
+				Address: "localhost:6379",
+				PubSub: PubSubConfiguration{
+					Durable: DurablePubSubConfiguration{
+						Enabled:    true,
+						Subscriber: "subscriber",
+					},
+				},
+			})).To(Succeed())
+			Expect(service.Start()).To(Succeed())
+			_, err := service.Del(context.Background(), "pubsub:test-durable")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			service.Stop()
+		})
+
+		It("should subscribe and publish messages", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			onSubscribed := func() error {
+				Expect(service.Publish(ctx, "test-durable", "hello from subscription")).To(Succeed())
+				return nil
+			}
+
+			Expect(service.Subscribe(ctx, onSubscribed, func(channel string, data []byte) error {
+				Expect(channel).To(Equal("test-durable"))
+				Expect(data).To(Equal([]byte("\"hello from subscription\"")))
+				cancel()
+				return nil
+			}, "test-durable")).To(Succeed())
+
+			close(done)
+		})
+
+		It("should not subscribe to patterns", func() {
+			Expect(service.PSubscribe(context.Background(), nil, nil, "test-*")).To(Equal(ErrDurablePatterns))
+		})
+
+		It("should catch up the messages published while offline", func(done Done) {
+			ctx, cancel := context.WithCancel(context.Background())
+			Expect(service.Subscribe(ctx, func() error {
+				cancel()
+				return nil
+			}, func(channel string, data []byte) error {
+				Fail("subscription handler should not be called")
+				return nil
+			}, "test-durable")).To(Succeed())
+
+			Expect(service.Publish(context.Background(), "test-durable", []byte("first"))).To(Succeed())
+			Expect(service.Publish(context.Background(), "test-durable", []byte("second"))).To(Succeed())
+
+			var received []string
+			ctx, cancel = context.WithCancel(context.Background())
+			Expect(service.Subscribe(ctx, func() error {
+				return nil
+			}, func(channel string, data []byte) error {
+				received = append(received, string(data))
+				if len(received) == 2 {
+					cancel()
+				}
+				return nil
+			}, "test-durable")).To(Succeed())
+			Expect(received).To(Equal([]string{"first", "second"}))
+
+			close(done)
+		})
+	})
 })
@@ -0,0 +1,447 @@
+package redigosrv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrJobNotFound is returned when acknowledging a job that is not being
+// processed anymore, because it was already acknowledged or its visibility
+// timeout expired and it was requeued.
+var ErrJobNotFound = errors.New("job not found")
+
+const (
+	queueAckScriptName = "redigosrv:queue:ack"
+	queueAckScript     = `
+local removed = redis.call("LREM", KEYS[1], 1, ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+return removed
+`
+
+	// The job is moved with its retry count updated, so it is removed from
+	// the processing list and pushed as a new value.
+	queueRequeueScriptName = "redigosrv:queue:requeue"
+	queueRequeueScript     = `
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("ZREM", KEYS[3], ARGV[1])
+redis.call("LPUSH", KEYS[2], ARGV[2])
+return 1
+`
+)
+
+// QueueOptions are the options of a `Queue`.
+type QueueOptions struct {
+	// VisibilityTimeout is how long a job can be processed before it is
+	// considered stalled and requeued. Defaults to 30 seconds.
+	VisibilityTimeout time.Duration
+	// MaxRetries is how many times a job is requeued before it is moved to
+	// the dead-letter list. Defaults to 3.
+	MaxRetries int
+	// ReapInterval is the time between the checks for stalled jobs done by
+	// `Run`. Defaults to half the visibility timeout.
+	ReapInterval time.Duration
+	// BlockTimeout is how long a worker blocks waiting for a job. Defaults to
+	// 1 second.
+	BlockTimeout time.Duration
+}
+
+func (opts QueueOptions) withDefaults() QueueOptions {
+	if opts.VisibilityTimeout == 0 {
+		opts.VisibilityTimeout = 30 * time.Second
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.ReapInterval == 0 {
+		opts.ReapInterval = opts.VisibilityTimeout / 2
+	}
+	if opts.BlockTimeout == 0 {
+		opts.BlockTimeout = time.Second
+	}
+	return opts
+}
+
+// Queue is a reliable work queue on Redis lists. Workers move the jobs into
+// their own processing list, so a job is not lost when a worker crashes: it
+// is requeued once its visibility timeout expires. Jobs failing more than
+// the maximum retries are moved to a dead-letter list.
+type Queue struct {
+	service *RedigoService
+	name    string
+	opts    QueueOptions
+	// err is the error registering the scripts, returned by `Dequeue` and
+	// `Reap`.
+	err error
+
+	// noBLMove is set when the server does not support BLMOVE (before Redis
+	// 6.2), so BRPOPLPUSH is used.
+	noBLMove int32
+}
+
+// Job is a job taken from a `Queue`. It must be acknowledged with `Ack`, or
+// `Nack` to be retried.
+type Job struct {
+	ID      string `json:"id"`
+	Payload []byte `json:"payload"`
+	// Retries is how many times the job was requeued.
+	Retries int `json:"retries"`
+
+	queue      *Queue
+	raw        string
+	processing string
+}
+
+// QueueDepth is the number of jobs in each list of a `Queue`.
+type QueueDepth struct {
+	Pending    int64
+	Processing int64
+	Dead       int64
+}
+
+// JobHandler processes a job. Returning an error retries the job.
+type JobHandler func(ctx context.Context, job *Job) error
+
+// NewQueue returns a new `Queue`.
+func (service *RedigoService) NewQueue(name string, opts QueueOptions) *Queue {
+	queue := &Queue{
+		service: service,
+		name:    name,
+		opts:    opts.withDefaults(),
+	}
+	queue.err = queue.registerScripts()
+	return queue
+}
+
+func (queue *Queue) pendingKey() string {
+	return "queue:" + queue.name + ":pending"
+}
+
+func (queue *Queue) processingKey(worker string) string {
+	return "queue:" + queue.name + ":processing:" + worker
+}
+
+func (queue *Queue) deadlinesKey() string {
+	return "queue:" + queue.name + ":deadlines"
+}
+
+func (queue *Queue) deadKey() string {
+	return "queue:" + queue.name + ":dead"
+}
+
+func (queue *Queue) registerScripts() error {
+	if _, err := queue.service.registerScript(queueAckScriptName, 2, queueAckScript); err != nil {
+		return err
+	}
+	_, err := queue.service.registerScript(queueRequeueScriptName, 3, queueRequeueScript)
+	return err
+}
+
+// Name returns the name of the queue.
+func (queue *Queue) Name() string {
+	return queue.name
+}
+
+// Enqueue adds a job to the queue returning its ID.
+func (queue *Queue) Enqueue(ctx context.Context, payload []byte) (string, error) {
+	id, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(&Job{ID: id, Payload: payload})
+	if err != nil {
+		return "", err
+	}
+	_, err = queue.service.LPush(ctx, queue.pendingKey(), raw)
+	return id, err
+}
+
+// Dequeue takes the oldest job moving it into the processing list of the
+// worker, waiting up to the block timeout. It returns `ErrNil` when no job is
+// available.
+func (queue *Queue) Dequeue(ctx context.Context, worker string) (*Job, error) {
+	if queue.err != nil {
+		return nil, queue.err
+	}
+
+	processing := queue.processingKey(worker)
+	timeout := strconv.FormatFloat(queue.opts.BlockTimeout.Seconds(), 'f', -1, 64)
+	var raw string
+	var err error
+	if atomic.LoadInt32(&queue.noBLMove) == 0 {
+		raw, err = redis.String(queue.service.Do(ctx, "BLMOVE", queue.pendingKey(), processing, "RIGHT", "LEFT", timeout))
+		if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "ERR unknown command") {
+			atomic.StoreInt32(&queue.noBLMove, 1)
+		}
+	}
+	if atomic.LoadInt32(&queue.noBLMove) == 1 {
+		raw, err = redis.String(queue.service.Do(ctx, "BRPOPLPUSH", queue.pendingKey(), processing, timeout))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		queue:      queue,
+		raw:        raw,
+		processing: processing,
+	}
+	if err := json.Unmarshal([]byte(raw), job); err != nil {
+		return nil, err
+	}
+	// If the worker crashes before setting the deadline, the reaper sets it.
+	_, err = job.setDeadline(ctx)
+	return job, err
+}
+
+// setDeadline sets when the visibility timeout of the job expires. The
+// options are sent before the score.
+func (job *Job) setDeadline(ctx context.Context, options ...interface{}) (int, error) {
+	deadline := time.Now().Add(job.queue.opts.VisibilityTimeout).UnixNano() / int64(time.Millisecond)
+	args := redis.Args{job.queue.deadlinesKey()}.Add(options...).Add(deadline, job.raw)
+	return redis.Int(job.queue.service.Do(ctx, "ZADD", args...))
+}
+
+// Extend resets the visibility timeout of the job, for jobs that take long to
+// process. It returns `ErrJobNotFound` if the job was already requeued.
+func (job *Job) Extend(ctx context.Context) error {
+	n, err := job.setDeadline(ctx, "XX", "CH")
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Ack acknowledges the job was processed, removing it from the queue.
+func (job *Job) Ack(ctx context.Context) error {
+	n, err := redis.Int(job.queue.service.EvalScript(ctx, queueAckScriptName, job.processing, job.queue.deadlinesKey(), job.raw))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Nack requeues the job to be retried or, if it was retried too many times,
+// moves it to the dead-letter list.
+func (job *Job) Nack(ctx context.Context) error {
+	moved, err := job.queue.requeue(ctx, job.processing, job.raw)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// requeue moves the job from the processing list back to the pending list,
+// or to the dead-letter list, incrementing its retries.
+func (queue *Queue) requeue(ctx context.Context, processing, raw string) (bool, error) {
+	job := &Job{}
+	if err := json.Unmarshal([]byte(raw), job); err != nil {
+		return false, err
+	}
+	job.Retries++
+	destination := queue.pendingKey()
+	if job.Retries > queue.opts.MaxRetries {
+		destination = queue.deadKey()
+	}
+	newRaw, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := redis.Int(queue.service.EvalScript(ctx, queueRequeueScriptName, processing, destination, queue.deadlinesKey(), raw, newRaw))
+	return n == 1, err
+}
+
+// Reap requeues the stalled jobs, whose visibility timeout expired, returning
+// how many were requeued. It is called periodically by `Run`, but it can be
+// called by any process.
+func (queue *Queue) Reap(ctx context.Context) (int, error) {
+	if queue.err != nil {
+		return 0, queue.err
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	deadline := now + int64(queue.opts.VisibilityTimeout/time.Millisecond)
+	reaped := 0
+
+	it := queue.service.Scan(ctx, ScanOptions{Match: queue.processingKey("*"), Type: "list"})
+	defer it.Close()
+	for it.Next() {
+		jobs, err := redis.Strings(queue.service.Do(ctx, "LRANGE", it.Val(), 0, -1))
+		if err != nil {
+			return reaped, err
+		}
+		for _, raw := range jobs {
+			score, err := redis.Float64(queue.service.Do(ctx, "ZSCORE", queue.deadlinesKey(), raw))
+			if err == ErrNil {
+				// The worker did not set the deadline, so it starts now.
+				_, err = queue.service.Do(ctx, "ZADD", queue.deadlinesKey(), "NX", deadline, raw)
+				if err != nil {
+					return reaped, err
+				}
+				continue
+			}
+			if err != nil {
+				return reaped, err
+			}
+			if int64(score) > now {
+				continue
+			}
+			moved, err := queue.requeue(ctx, it.Val(), raw)
+			if err != nil {
+				return reaped, err
+			}
+			if moved {
+				reaped++
+			}
+		}
+	}
+	return reaped, it.Err()
+}
+
+// Depth returns the number of jobs in each list of the queue, updating the
+// queue depth metric.
+func (queue *Queue) Depth(ctx context.Context) (*QueueDepth, error) {
+	depth := &QueueDepth{}
+	var err error
+	if depth.Pending, err = queue.service.LLen(ctx, queue.pendingKey()); err != nil {
+		return nil, err
+	}
+	if depth.Dead, err = queue.service.LLen(ctx, queue.deadKey()); err != nil {
+		return nil, err
+	}
+	it := queue.service.Scan(ctx, ScanOptions{Match: queue.processingKey("*"), Type: "list", Deduplicate: true})
+	for it.Next() {
+		n, err := queue.service.LLen(ctx, it.Val())
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		depth.Processing += n
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	gauge := queue.service.Collector.queueDepth
+	gauge.With(prometheus.Labels{"queue": queue.name, "state": "pending"}).Set(float64(depth.Pending))
+	gauge.With(prometheus.Labels{"queue": queue.name, "state": "processing"}).Set(float64(depth.Processing))
+	gauge.With(prometheus.Labels{"queue": queue.name, "state": "dead"}).Set(float64(depth.Dead))
+	return depth, nil
+}
+
+// DeadLetters returns the jobs in the dead-letter list.
+func (queue *Queue) DeadLetters(ctx context.Context) ([]*Job, error) {
+	raws, err := redis.Strings(queue.service.Do(ctx, "LRANGE", queue.deadKey(), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, len(raws))
+	for i, raw := range raws {
+		jobs[i] = &Job{queue: queue, raw: raw}
+		if err := json.Unmarshal([]byte(raw), jobs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+// Run processes the jobs using the given number of workers until the context
+// is cancelled. Jobs are acknowledged when the handler succeeds and retried
+// otherwise. Stalled jobs are reaped and the depth metric is updated every
+// reap interval.
+func (queue *Queue) Run(ctx context.Context, workers int, handler JobHandler) error {
+	prefix, err := newLockToken()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			queue.work(ctx, worker, handler)
+		}(fmt.Sprintf("%s-%d", prefix[:8], i))
+	}
+
+	ticker := time.NewTicker(queue.opts.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := queue.Reap(ctx); err != nil && ctx.Err() == nil {
+				queue.service.logger().Printf("redigosrv: queue %s could not reap stalled jobs: %s", queue.name, err)
+			}
+			if _, err := queue.Depth(ctx); err != nil && ctx.Err() == nil {
+				queue.service.logger().Printf("redigosrv: queue %s could not read its depth: %s", queue.name, err)
+			}
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		}
+	}
+}
+
+// work takes and processes jobs until the context is cancelled.
+func (queue *Queue) work(ctx context.Context, worker string, handler JobHandler) {
+	for ctx.Err() == nil {
+		job, err := queue.Dequeue(ctx, worker)
+		if err == ErrNil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			queue.service.logger().Printf("redigosrv: queue %s worker %s could not take a job: %s", queue.name, worker, err)
+			select {
+			case <-time.After(queue.opts.BlockTimeout):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		// The job is acknowledged even if the context was cancelled while
+		// processing it.
+		if err := queue.handle(ctx, job, handler); err != nil {
+			queue.service.logger().Printf("redigosrv: queue %s job %s failed: %s", queue.name, job.ID, err)
+			err = job.Nack(context.Background())
+		} else {
+			err = job.Ack(context.Background())
+		}
+		if err != nil {
+			queue.service.logger().Printf("redigosrv: queue %s could not complete job %s: %s", queue.name, job.ID, err)
+		}
+	}
+}
+
+// handle calls the handler turning panics into errors.
+func (queue *Queue) handle(ctx context.Context, job *Job, handler JobHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,160 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Queue", func() {
+	var service RedigoService
+	var queue *Queue
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+
+		it := service.Scan(ctx, ScanOptions{Match: "queue:jobs:*"})
+		for it.Next() {
+			_, err := service.Del(ctx, it.Val())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(it.Err()).ToNot(HaveOccurred())
+
+		queue = service.NewQueue("jobs", QueueOptions{
+			VisibilityTimeout: 100 * time.Millisecond,
+			MaxRetries:        1,
+			BlockTimeout:      100 * time.Millisecond,
+		})
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	depth := func() QueueDepth {
+		depth, err := queue.Depth(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		return *depth
+	}
+
+	It("should dequeue and acknowledge jobs", func() {
+		id, err := queue.Enqueue(ctx, []byte("first"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = queue.Enqueue(ctx, []byte("second"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(depth()).To(Equal(QueueDepth{Pending: 2}))
+
+		job, err := queue.Dequeue(ctx, "w1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.ID).To(Equal(id))
+		Expect(job.Payload).To(Equal([]byte("first")))
+		Expect(depth()).To(Equal(QueueDepth{Pending: 1, Processing: 1}))
+
+		Expect(job.Ack(ctx)).To(Succeed())
+		Expect(job.Ack(ctx)).To(Equal(ErrJobNotFound))
+		Expect(depth()).To(Equal(QueueDepth{Pending: 1}))
+
+		var metric dto.Metric
+		Expect(service.Collector.queueDepth.With(prometheus.Labels{"queue": "jobs", "state": "pending"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should return ErrNil when the queue is empty", func() {
+		_, err := queue.Dequeue(ctx, "w1")
+		Expect(err).To(Equal(ErrNil))
+	})
+
+	It("should return the error registering its scripts", func() {
+		var other RedigoService
+		Expect(other.RegisterScript(queueRequeueScriptName, 3, "return 1")).To(Succeed())
+
+		queue := other.NewQueue("jobs", QueueOptions{})
+		_, err := queue.Dequeue(ctx, "worker")
+		Expect(err).To(Equal(ErrScriptAlreadyRegistered))
+		_, err = queue.Reap(ctx)
+		Expect(err).To(Equal(ErrScriptAlreadyRegistered))
+	})
+
+	It("should retry nacked jobs and then dead-letter them", func() {
+		_, err := queue.Enqueue(ctx, []byte("failing"))
+		Expect(err).ToNot(HaveOccurred())
+
+		job, err := queue.Dequeue(ctx, "w1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Nack(ctx)).To(Succeed())
+
+		job, err = queue.Dequeue(ctx, "w1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Retries).To(Equal(1))
+		Expect(job.Nack(ctx)).To(Succeed())
+		Expect(depth()).To(Equal(QueueDepth{Dead: 1}))
+
+		dead, err := queue.DeadLetters(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dead).To(HaveLen(1))
+		Expect(dead[0].Payload).To(Equal([]byte("failing")))
+		Expect(dead[0].Retries).To(Equal(2))
+	})
+
+	It("should requeue stalled jobs", func() {
+		_, err := queue.Enqueue(ctx, []byte("stalled"))
+		Expect(err).ToNot(HaveOccurred())
+
+		job, err := queue.Dequeue(ctx, "crashed")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queue.Reap(ctx)).To(Equal(0))
+		Expect(job.Extend(ctx)).To(Succeed())
+
+		time.Sleep(150 * time.Millisecond)
+		Expect(queue.Reap(ctx)).To(Equal(1))
+		Expect(depth()).To(Equal(QueueDepth{Pending: 1}))
+		Expect(job.Ack(ctx)).To(Equal(ErrJobNotFound))
+		Expect(job.Extend(ctx)).To(Equal(ErrJobNotFound))
+
+		job, err = queue.Dequeue(ctx, "w1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Payload).To(Equal([]byte("stalled")))
+		Expect(job.Retries).To(Equal(1))
+	})
+
+	It("should process jobs with a pool of workers", func() {
+		for _, payload := range []string{"a", "b", "c", "fail"} {
+			_, err := queue.Enqueue(ctx, []byte(payload))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		var mu sync.Mutex
+		processed := map[string]int{}
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- queue.Run(runCtx, 2, func(ctx context.Context, job *Job) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[string(job.Payload)]++
+				if string(job.Payload) == "fail" {
+					return errors.New("failed")
+				}
+				return nil
+			})
+		}()
+
+		Eventually(depth).Should(Equal(QueueDepth{Dead: 1}))
+		cancel()
+		Eventually(done, time.Second).Should(Receive(BeNil()))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(processed).To(Equal(map[string]int{"a": 1, "b": 1, "c": 1, "fail": 2}))
+	})
+})
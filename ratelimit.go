@@ -0,0 +1,245 @@
+package redigosrv
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// All the rate limiter scripts use the time of the Redis server, so the
+// replicas sharing the limits do not depend on their clocks. They return
+// {allowed, remaining, retry after (ms), reset after (ms)}.
+const (
+	fixedWindowScriptName = "redigosrv:ratelimit:fixed_window"
+	fixedWindowScript     = `
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local start = now - (now % window)
+local reset = start + window - now
+
+local current = redis.call("HMGET", KEYS[1], "start", "count")
+local count = 0
+if tonumber(current[1]) == start then
+	count = tonumber(current[2])
+end
+if count + cost > limit then
+	return {0, limit - count, reset, reset}
+end
+count = count + cost
+redis.call("HMSET", KEYS[1], "start", start, "count", count)
+redis.call("PEXPIRE", KEYS[1], reset)
+return {1, limit - count, 0, reset}
+`
+
+	slidingWindowScriptName = "redigosrv:ratelimit:sliding_window"
+	slidingWindowScript     = `
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + cost > limit then
+	if cost > limit then
+		return {0, limit - count, window, window}
+	end
+	local oldest = redis.call("ZRANGE", KEYS[1], count + cost - limit - 1, count + cost - limit - 1, "WITHSCORES")
+	local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	return {0, limit - count, tonumber(oldest[2]) + window - now, tonumber(newest[2]) + window - now}
+end
+for i = 1, cost do
+	redis.call("ZADD", KEYS[1], now, ARGV[4] .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, limit - count - cost, 0, window}
+`
+
+	tokenBucketScriptName = "redigosrv:ratelimit:token_bucket"
+	tokenBucketScript     = `
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local emission = tonumber(ARGV[2]) / tonumber(ARGV[1])
+local tolerance = emission * tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+local newTat = tat + emission * cost
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, math.floor((tolerance - (tat - now)) / emission), math.ceil(allowAt - now), math.ceil(tat - now)}
+end
+redis.call("SET", KEYS[1], newTat, "PX", math.ceil(newTat - now))
+return {1, math.floor((tolerance - (newTat - now)) / emission), 0, math.ceil(newTat - now)}
+`
+)
+
+// RateLimitResult is the decision of a `RateLimiter`.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is how many requests are still allowed.
+	Remaining int64
+	// RetryAfter is how long to wait before the request would be allowed. It
+	// is zero when the request was allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the limiter is fully reset.
+	ResetAfter time.Duration
+}
+
+// RateLimiter limits the rate of requests per key. The limits are shared by
+// every replica using the same Redis.
+type RateLimiter struct {
+	service    *RedigoService
+	name       string
+	scriptName string
+	limit      int64
+	args       []interface{}
+	// err is the error registering the script, returned by `AllowN`.
+	err error
+}
+
+// newRateLimiter returns a limiter registering its script once. Failing to
+// load the script does not disable the limiter, as it is sent with EVAL
+// when not loaded.
+func (service *RedigoService) newRateLimiter(name, scriptName, script string, limit int64, args ...interface{}) *RateLimiter {
+	_, err := service.registerScript(scriptName, 1, script)
+	return &RateLimiter{
+		service:    service,
+		name:       name,
+		scriptName: scriptName,
+		limit:      limit,
+		args:       args,
+		err:        err,
+	}
+}
+
+// FixedWindowLimiter returns a limiter that allows `limit` requests per
+// window, counting from the beginning of each window.
+func (service *RedigoService) FixedWindowLimiter(name string, limit int64, window time.Duration) *RateLimiter {
+	return service.newRateLimiter(name, fixedWindowScriptName, fixedWindowScript, limit, limit, int64(window/time.Millisecond))
+}
+
+// SlidingWindowLimiter returns a limiter that allows `limit` requests in any
+// window, keeping a log of the requests.
+func (service *RedigoService) SlidingWindowLimiter(name string, limit int64, window time.Duration) *RateLimiter {
+	return service.newRateLimiter(name, slidingWindowScriptName, slidingWindowScript, limit, limit, int64(window/time.Millisecond))
+}
+
+// TokenBucketLimiter returns a limiter that allows `rate` requests per period
+// with bursts of up to `burst` requests. It is implemented using the generic
+// cell rate algorithm (GCRA).
+func (service *RedigoService) TokenBucketLimiter(name string, rate int64, period time.Duration, burst int64) *RateLimiter {
+	return service.newRateLimiter(name, tokenBucketScriptName, tokenBucketScript, burst, rate, int64(period/time.Millisecond), burst)
+}
+
+// Name returns the name of the limiter.
+func (limiter *RateLimiter) Name() string {
+	return limiter.name
+}
+
+// Limit returns the maximum number of requests allowed at once.
+func (limiter *RateLimiter) Limit() int64 {
+	return limiter.limit
+}
+
+// Allow checks if a request for the key is allowed.
+func (limiter *RateLimiter) Allow(ctx context.Context, key string) (*RateLimitResult, error) {
+	return limiter.AllowN(ctx, key, 1)
+}
+
+// AllowN checks if n requests for the key are allowed. The requests are only
+// counted when allowed.
+func (limiter *RateLimiter) AllowN(ctx context.Context, key string, n int64) (*RateLimitResult, error) {
+	if limiter.err != nil {
+		return nil, limiter.err
+	}
+
+	args := redis.Args{"ratelimit:" + limiter.name + ":" + key}.Add(limiter.args...).Add(n)
+	if limiter.scriptName == slidingWindowScriptName {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		args = args.Add(hex.EncodeToString(id))
+	}
+
+	values, err := redis.Int64s(limiter.service.EvalScript(ctx, limiter.scriptName, args...))
+	if err != nil {
+		return nil, err
+	}
+	result := &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		ResetAfter: time.Duration(values[3]) * time.Millisecond,
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	decision := "allowed"
+	if !result.Allowed {
+		decision = "denied"
+	}
+	limiter.service.Collector.rateLimitDecisions.With(prometheus.Labels{
+		"limiter":  limiter.name,
+		"decision": decision,
+	}).Inc()
+
+	return result, nil
+}
+
+// RateLimitKeyFunc extracts the rate limit key from a request. Returning an
+// empty key skips the limiter.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// Middleware returns a HTTP middleware that limits the requests using the
+// key extracted from each request. It sets the X-RateLimit-* headers and
+// replies 429 when the request is denied. If Redis cannot be reached, the
+// request is let through and the error is logged.
+func (limiter *RateLimiter) Middleware(keyFunc RateLimitKeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				limiter.service.logger().Printf("redigosrv: rate limiter %s failed: %s", limiter.name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("X-RateLimit-Limit", strconv.FormatInt(limiter.limit, 10))
+			header.Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+			header.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(result.ResetAfter.Seconds())), 10))
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(result.RetryAfter.Seconds())), 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
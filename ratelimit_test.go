@@ -0,0 +1,141 @@
+package redigosrv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "ratelimit:fixed:tenant", "ratelimit:sliding:tenant", "ratelimit:bucket:tenant", "ratelimit:http:tenant")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	allowed := func(limiter *RateLimiter) bool {
+		result, err := limiter.Allow(ctx, "tenant")
+		Expect(err).ToNot(HaveOccurred())
+		return result.Allowed
+	}
+
+	expectLimited := func(limiter *RateLimiter, limit int64) {
+		for i := int64(1); i <= limit; i++ {
+			result, err := limiter.Allow(ctx, "tenant")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Allowed).To(BeTrue())
+			Expect(result.Remaining).To(Equal(limit - i))
+		}
+		result, err := limiter.Allow(ctx, "tenant")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Allowed).To(BeFalse())
+		Expect(result.Remaining).To(Equal(int64(0)))
+		Expect(result.RetryAfter).To(BeNumerically(">", 0))
+		Expect(result.RetryAfter).To(BeNumerically("<=", time.Minute))
+	}
+
+	It("should limit using a fixed window", func() {
+		limiter := service.FixedWindowLimiter("fixed", 3, time.Minute)
+		expectLimited(limiter, 3)
+
+		var metric dto.Metric
+		Expect(service.Collector.rateLimitDecisions.With(prometheus.Labels{
+			"limiter":  "fixed",
+			"decision": "denied",
+		}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should return the error registering its script", func() {
+		var other RedigoService
+		Expect(other.RegisterScript(tokenBucketScriptName, 1, "return 1")).To(Succeed())
+
+		limiter := other.TokenBucketLimiter("bucket", 1, time.Second, 1)
+		_, err := limiter.Allow(ctx, "tenant")
+		Expect(err).To(Equal(ErrScriptAlreadyRegistered))
+	})
+
+	It("should not be disabled when its script fails to load", func() {
+		var other RedigoService
+		Expect(other.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(other.Start()).To(Succeed())
+		defer other.Stop()
+
+		// The pool is exhausted, so the script cannot be loaded.
+		other.pool.MaxActive = 1
+		conn, err := other.GetConn()
+		Expect(err).ToNot(HaveOccurred())
+		limiter := other.FixedWindowLimiter("fixed", 3, time.Minute)
+		Expect(conn.Close()).To(Succeed())
+
+		Expect(limiter.err).ToNot(HaveOccurred())
+		expectLimited(limiter, 3)
+	})
+
+	It("should limit using a sliding window", func() {
+		expectLimited(service.SlidingWindowLimiter("sliding", 3, time.Minute), 3)
+	})
+
+	It("should allow again after the sliding window", func() {
+		limiter := service.SlidingWindowLimiter("sliding", 1, 100*time.Millisecond)
+		Expect(allowed(limiter)).To(BeTrue())
+		Expect(allowed(limiter)).To(BeFalse())
+		time.Sleep(150 * time.Millisecond)
+		Expect(allowed(limiter)).To(BeTrue())
+	})
+
+	It("should limit using a token bucket", func() {
+		expectLimited(service.TokenBucketLimiter("bucket", 1, time.Minute, 3), 3)
+	})
+
+	It("should refill the token bucket", func() {
+		limiter := service.TokenBucketLimiter("bucket", 10, time.Second, 1)
+		Expect(allowed(limiter)).To(BeTrue())
+		result, err := limiter.Allow(ctx, "tenant")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Allowed).To(BeFalse())
+		time.Sleep(result.RetryAfter)
+		Expect(allowed(limiter)).To(BeTrue())
+	})
+
+	It("should limit HTTP requests", func() {
+		limiter := service.FixedWindowLimiter("http", 1, time.Minute)
+		handler := limiter.Middleware(func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("X-Tenant", "tenant")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(recorder.Header().Get("X-RateLimit-Limit")).To(Equal("1"))
+		Expect(recorder.Header().Get("X-RateLimit-Remaining")).To(Equal("0"))
+
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(recorder.Header().Get("Retry-After")).ToNot(BeEmpty())
+	})
+})
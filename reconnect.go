@@ -0,0 +1,133 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+)
+
+// ReconnectConfiguration is the configuration of the subscriptions that
+// reconnect when their connection fails.
+type ReconnectConfiguration struct {
+	// MinBackoff is the wait before the first reconnection attempt, doubled
+	// after each failed attempt. Defaults to 100 milliseconds.
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	// MaxBackoff limits the wait between the attempts. Defaults to 30
+	// seconds.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// MaxAttempts is the number of attempts in a row before giving up,
+	// returning the error of the connection. Unlimited if zero.
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+// ReconnectedHandler is called when a subscription is subscribed again
+// after its connection failed. Messages published meanwhile are lost, so it
+// can be used to resync the state of the application.
+type ReconnectedHandler func() error
+
+// connectionLostError is a failure of the connection of a subscription, as
+// opposed to an error returned by its handlers.
+type connectionLostError struct {
+	err error
+}
+
+func (err *connectionLostError) Error() string {
+	return err.err.Error()
+}
+
+// unwrapConnectionLost returns the error of the connection, if err is a
+// `connectionLostError`.
+func unwrapConnectionLost(err error) error {
+	if lost, ok := err.(*connectionLostError); ok {
+		return lost.err
+	}
+	return err
+}
+
+// SubscribeWithReconnect works as `Subscribe`, but when the connection
+// fails it dials again, with an exponential backoff, and subscribes again
+// to all the channels. The subscribed function is called only once, the
+// reconnected function, if set, is called after each reconnection. Errors
+// returned by the handlers are still returned.
+//
+// In durable mode, the streams are consumed again in the same way, and the
+// messages published meanwhile are not lost.
+func (service *RedigoService) SubscribeWithReconnect(ctx context.Context, subscribed SubscribedHandler, reconnected ReconnectedHandler, subscription SubscriptionHandler, channels ...string) error {
+	if service.Configuration.PubSub.Durable.Enabled {
+		return service.subscribeWithReconnect(ctx, subscribed, reconnected, func(subscribed SubscribedHandler) error {
+			return service.subscribeDurable(ctx, subscribed, subscription, channels...)
+		})
+	}
+	return service.subscribeWithReconnect(ctx, subscribed, reconnected, func(subscribed SubscribedHandler) error {
+		return service.subscribe(ctx, subscribed, func(pattern, channel string, data []byte) error {
+			return subscription(channel, data)
+		}, channels, nil)
+	})
+}
+
+// PSubscribeWithReconnect works as `PSubscribe`, reconnecting as
+// `SubscribeWithReconnect` does.
+func (service *RedigoService) PSubscribeWithReconnect(ctx context.Context, subscribed SubscribedHandler, reconnected ReconnectedHandler, subscription PatternSubscriptionHandler, patterns ...string) error {
+	if service.Configuration.PubSub.Durable.Enabled {
+		return ErrDurablePatterns
+	}
+	return service.subscribeWithReconnect(ctx, subscribed, reconnected, func(subscribed SubscribedHandler) error {
+		return service.subscribe(ctx, subscribed, subscription, nil, patterns)
+	})
+}
+
+// subscribeWithReconnect calls subscribe again, with the backoff, while it
+// fails with a `connectionLostError`.
+func (service *RedigoService) subscribeWithReconnect(ctx context.Context, subscribed SubscribedHandler, reconnected ReconnectedHandler, subscribe func(subscribed SubscribedHandler) error) error {
+	config := service.Configuration.PubSub.Reconnect
+	backoff := newBackoff(config.MinBackoff, config.MaxBackoff)
+	attempts := 0
+	connected := false
+
+	for {
+		// ok is set by the receiving goroutine, which is done when subscribe
+		// returns.
+		ok := false
+		err := subscribe(func() error {
+			ok = true
+			if !connected {
+				connected = true
+				return subscribed()
+			}
+
+			// Increment to count reconnections
+			service.Collector.subscribeReconnects.Inc()
+
+			if reconnected != nil {
+				return reconnected()
+			}
+			return nil
+		})
+
+		lost, isLost := err.(*connectionLostError)
+		if !isLost {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if ok {
+			// The connection was working, so the backoff starts over.
+			backoff.reset()
+			attempts = 0
+		}
+		attempts++
+		if config.MaxAttempts > 0 && attempts > config.MaxAttempts {
+			return lost.err
+		}
+
+		delay := backoff.next()
+		service.logger().Printf("redigosrv: subscription lost, reconnecting in %s: %s", delay, lost.err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Reconnect)", func() {
+	var service RedigoService
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			PubSub: PubSubConfiguration{
+				Reconnect: ReconnectConfiguration{
+					MinBackoff: 10 * time.Millisecond,
+				},
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	reconnects := func() float64 {
+		var metric dto.Metric
+		Expect(service.Collector.subscribeReconnects.Write(&metric)).To(Succeed())
+		return metric.GetCounter().GetValue()
+	}
+
+	It("should resubscribe when the connection fails", func(done Done) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		subscribed, reconnected := 0, 0
+		onSubscribed := func() error {
+			subscribed++
+			_, err := service.Do(ctx, "CLIENT", "KILL", "TYPE", "pubsub")
+			return err
+		}
+		onReconnected := func() error {
+			reconnected++
+			return service.Publish(ctx, "test-reconnect", []byte("after"))
+		}
+
+		Expect(service.SubscribeWithReconnect(ctx, onSubscribed, onReconnected, func(channel string, data []byte) error {
+			Expect(channel).To(Equal("test-reconnect"))
+			Expect(data).To(Equal([]byte("after")))
+			cancel()
+			return nil
+		}, "test-reconnect")).To(Succeed())
+
+		Expect(subscribed).To(Equal(1))
+		Expect(reconnected).To(Equal(1))
+		Expect(reconnects()).To(Equal(float64(1)))
+
+		close(done)
+	})
+
+	It("should consume again the durable streams when the connection fails", func(done Done) {
+		service.Configuration.PubSub.Durable = DurablePubSubConfiguration{
+			Enabled:    true,
+			Subscriber: "test",
+			Consumer:   "test-1",
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		subscribed, reconnected := 0, 0
+		onSubscribed := func() error {
+			subscribed++
+			// The pool is exhausted, so the streams cannot be read until
+			// the connections are released.
+			conns := []redis.Conn{service.pool.Get()}
+			for service.pool.IdleCount() > 0 {
+				conns = append(conns, service.pool.Get())
+			}
+			service.pool.MaxActive = service.pool.ActiveCount()
+			time.AfterFunc(50*time.Millisecond, func() {
+				for _, conn := range conns {
+					conn.Close()
+				}
+			})
+			return nil
+		}
+		onReconnected := func() error {
+			reconnected++
+			return service.Publish(ctx, "test-reconnect-durable", []byte("after"))
+		}
+
+		Expect(service.SubscribeWithReconnect(ctx, onSubscribed, onReconnected, func(channel string, data []byte) error {
+			Expect(channel).To(Equal("test-reconnect-durable"))
+			Expect(data).To(Equal([]byte("after")))
+			cancel()
+			return nil
+		}, "test-reconnect-durable")).To(Succeed())
+
+		Expect(subscribed).To(Equal(1))
+		Expect(reconnected).To(Equal(1))
+		Expect(reconnects()).To(Equal(float64(1)))
+
+		close(done)
+	})
+
+	It("should not resubscribe when the handler fails", func(done Done) {
+		ctx := context.Background()
+
+		onSubscribed := func() error {
+			return service.Publish(ctx, "test-reconnect", []byte("failing"))
+		}
+
+		Expect(service.PSubscribeWithReconnect(ctx, onSubscribed, nil, func(pattern, channel string, data []byte) error {
+			return errors.New("something bad")
+		}, "test-reconnect*")).To(MatchError("something bad"))
+		Expect(reconnects()).To(Equal(float64(0)))
+
+		close(done)
+	})
+
+	It("should give up after the max attempts", func(done Done) {
+		// The pool is already connected, only the subscriptions dial.
+		service.Configuration.Address = "localhost:1"
+		service.Configuration.PubSub.Reconnect.MaxAttempts = 2
+
+		err := service.SubscribeWithReconnect(context.Background(), func() error {
+			Fail("subscribed handler should not be called")
+			return nil
+		}, nil, func(channel string, data []byte) error {
+			return nil
+		}, "test-reconnect")
+		Expect(err).To(HaveOccurred())
+		Expect(err).ToNot(BeAssignableToTypeOf(&connectionLostError{}))
+		// Only the successful reconnections are counted.
+		Expect(reconnects()).To(Equal(float64(0)))
+
+		close(done)
+	})
+})
@@ -0,0 +1,231 @@
+package redigosrv
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ScanOptions are the options of the SCAN-family iterators.
+type ScanOptions struct {
+	// Match filters the elements using a glob-style pattern.
+	Match string
+	// Count is a hint of how many elements are returned by each call.
+	Count int64
+	// Type filters the keys by their type. Only used by SCAN.
+	Type string
+	// Deduplicate skips the elements returned more than once by the server.
+	// The elements returned are kept until the iteration is over, so it
+	// should be used only when the iteration is known to be small.
+	Deduplicate bool
+}
+
+// ScanElement is an element returned by a SCAN-family command.
+type ScanElement struct {
+	// Member is the key (SCAN), the field (HSCAN) or the member (SSCAN and
+	// ZSCAN).
+	Member string
+	// Value is the value of the field, only set by HSCAN.
+	Value string
+	// Score is the score of the member, only set by ZSCAN.
+	Score float64
+}
+
+// ScanIterator iterates over the elements returned by a SCAN-family command,
+// handling the cursor. Elements can be returned more than once by the server,
+// unless `ScanOptions.Deduplicate` is set. It holds a connection from the pool
+// until the iteration is over or it is closed.
+type ScanIterator struct {
+	service *RedigoService
+	ctx     context.Context
+	command string
+	key     string
+	opts    ScanOptions
+
+	conn     redis.Conn
+	cursor   int64
+	finished bool
+	seen     map[string]struct{}
+	batch    []ScanElement
+	pos      int
+	current  ScanElement
+	err      error
+}
+
+// Scan returns an iterator over the keys using SCAN.
+func (service *RedigoService) Scan(ctx context.Context, opts ScanOptions) *ScanIterator {
+	return service.newScanIterator(ctx, "SCAN", "", opts)
+}
+
+// HScan returns an iterator over the fields of a hash using HSCAN.
+func (service *RedigoService) HScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return service.newScanIterator(ctx, "HSCAN", key, opts)
+}
+
+// SScan returns an iterator over the members of a set using SSCAN.
+func (service *RedigoService) SScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return service.newScanIterator(ctx, "SSCAN", key, opts)
+}
+
+// ZScan returns an iterator over the members of a sorted set using ZSCAN.
+func (service *RedigoService) ZScan(ctx context.Context, key string, opts ScanOptions) *ScanIterator {
+	return service.newScanIterator(ctx, "ZSCAN", key, opts)
+}
+
+func (service *RedigoService) newScanIterator(ctx context.Context, command, key string, opts ScanOptions) *ScanIterator {
+	it := &ScanIterator{
+		service: service,
+		ctx:     ctx,
+		command: command,
+		key:     key,
+		opts:    opts,
+	}
+	if opts.Deduplicate {
+		it.seen = make(map[string]struct{})
+	}
+	return it
+}
+
+// Next advances to the next element, returning false when the iteration is
+// over or failed.
+func (it *ScanIterator) Next() bool {
+	for it.pos >= len(it.batch) {
+		if !it.fetch() {
+			return false
+		}
+	}
+	it.current = it.batch[it.pos]
+	it.pos++
+	return true
+}
+
+// Element returns the current element.
+func (it *ScanIterator) Element() ScanElement {
+	return it.current
+}
+
+// Val returns the member of the current element.
+func (it *ScanIterator) Val() string {
+	return it.current.Member
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+// Batches calls fn with the elements of each call to the server, until the
+// iteration is over or fn fails. The iterator is closed when it returns.
+func (it *ScanIterator) Batches(fn func(batch []ScanElement) error) error {
+	defer it.Close()
+
+	if rest := it.batch[it.pos:]; len(rest) > 0 {
+		it.pos = len(it.batch)
+		if err := fn(rest); err != nil {
+			return err
+		}
+	}
+	for it.fetch() {
+		it.pos = len(it.batch)
+		if len(it.batch) == 0 {
+			continue
+		}
+		if err := fn(it.batch); err != nil {
+			return err
+		}
+	}
+	return it.err
+}
+
+// Close releases the connection held by the iterator. It is called
+// automatically when the iteration is over or failed.
+func (it *ScanIterator) Close() error {
+	it.finished = true
+	if it.conn == nil {
+		return nil
+	}
+	err := it.conn.Close()
+	it.conn = nil
+	return err
+}
+
+// fetch calls the server for the next batch, returning false when the
+// iteration is over or failed.
+func (it *ScanIterator) fetch() bool {
+	if it.finished || it.err != nil {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		return it.fail(err)
+	}
+	if it.conn == nil {
+		conn, err := it.service.GetConn()
+		if err != nil {
+			return it.fail(err)
+		}
+		it.conn = conn
+	}
+
+	args := redis.Args{}
+	if it.key != "" {
+		args = args.Add(it.key)
+	}
+	args = args.Add(it.cursor)
+	if it.opts.Match != "" {
+		args = args.Add("MATCH", it.opts.Match)
+	}
+	if it.opts.Count > 0 {
+		args = args.Add("COUNT", it.opts.Count)
+	}
+	if it.opts.Type != "" && it.command == "SCAN" {
+		args = args.Add("TYPE", it.opts.Type)
+	}
+
+	reply, err := redis.Values(doContext(it.ctx, it.conn.(redis.ConnWithTimeout), it.command, args...))
+	if err != nil {
+		return it.fail(err)
+	}
+	var values []string
+	if _, err := redis.Scan(reply, &it.cursor, &values); err != nil {
+		return it.fail(err)
+	}
+
+	it.batch, it.pos = nil, 0
+	step := 1
+	if it.command == "HSCAN" || it.command == "ZSCAN" {
+		step = 2
+	}
+	for i := 0; i+step <= len(values); i += step {
+		element := ScanElement{Member: values[i]}
+		if it.seen != nil {
+			if _, ok := it.seen[element.Member]; ok {
+				continue
+			}
+			it.seen[element.Member] = struct{}{}
+		}
+
+		switch it.command {
+		case "HSCAN":
+			element.Value = values[i+1]
+		case "ZSCAN":
+			if element.Score, err = strconv.ParseFloat(values[i+1], 64); err != nil {
+				return it.fail(err)
+			}
+		}
+		it.batch = append(it.batch, element)
+	}
+
+	if it.cursor == 0 {
+		// The connection is released right away, the batch is still
+		// returned.
+		it.Close()
+	}
+	return true
+}
+
+func (it *ScanIterator) fail(err error) bool {
+	it.err = err
+	it.Close()
+	return false
+}
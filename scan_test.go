@@ -0,0 +1,139 @@
+package redigosrv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ScanIterator", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+
+		keys := []string{"scan-hash", "scan-set", "scan-zset"}
+		for i := 0; i < 50; i++ {
+			keys = append(keys, fmt.Sprintf("scan:%d", i))
+		}
+		_, err := service.Del(ctx, keys...)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should iterate over the keys", func() {
+		for i := 0; i < 50; i++ {
+			Expect(service.Set(ctx, fmt.Sprintf("scan:%d", i), i, 0)).To(Succeed())
+		}
+		_, err := service.SAdd(ctx, "scan-set", "a")
+		Expect(err).ToNot(HaveOccurred())
+
+		it := service.Scan(ctx, ScanOptions{Match: "scan:*", Count: 10})
+		var keys []string
+		for it.Next() {
+			keys = append(keys, it.Val())
+		}
+		Expect(it.Err()).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(50))
+		sort.Strings(keys)
+		Expect(keys[0]).To(Equal("scan:0"))
+		Expect(it.conn).To(BeNil())
+	})
+
+	It("should filter the keys by type", func() {
+		Expect(service.Set(ctx, "scan:1", "1", 0)).To(Succeed())
+		_, err := service.SAdd(ctx, "scan-set", "a")
+		Expect(err).ToNot(HaveOccurred())
+
+		it := service.Scan(ctx, ScanOptions{Match: "scan*", Type: "set"})
+		var keys []string
+		for it.Next() {
+			keys = append(keys, it.Val())
+		}
+		Expect(it.Err()).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{"scan-set"}))
+	})
+
+	It("should iterate over hashes, sets and sorted sets", func() {
+		Expect(service.HMSet(ctx, "scan-hash", map[string]interface{}{"a": "1", "b": "2"})).To(Succeed())
+		_, err := service.SAdd(ctx, "scan-set", "a", "b", "c")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = service.ZAdd(ctx, "scan-zset", ZMember{"a", 1.5}, ZMember{"b", 2})
+		Expect(err).ToNot(HaveOccurred())
+
+		fields := map[string]string{}
+		it := service.HScan(ctx, "scan-hash", ScanOptions{})
+		for it.Next() {
+			fields[it.Val()] = it.Element().Value
+		}
+		Expect(it.Err()).ToNot(HaveOccurred())
+		Expect(fields).To(Equal(map[string]string{"a": "1", "b": "2"}))
+
+		var members []string
+		it = service.SScan(ctx, "scan-set", ScanOptions{Match: "[ab]"})
+		for it.Next() {
+			members = append(members, it.Val())
+		}
+		sort.Strings(members)
+		Expect(members).To(Equal([]string{"a", "b"}))
+
+		scores := map[string]float64{}
+		it = service.ZScan(ctx, "scan-zset", ScanOptions{})
+		for it.Next() {
+			scores[it.Val()] = it.Element().Score
+		}
+		Expect(it.Err()).ToNot(HaveOccurred())
+		Expect(scores).To(Equal(map[string]float64{"a": 1.5, "b": 2}))
+	})
+
+	It("should call the batch callback", func() {
+		for i := 0; i < 50; i++ {
+			Expect(service.Set(ctx, fmt.Sprintf("scan:%d", i), i, 0)).To(Succeed())
+		}
+
+		total := 0
+		Expect(service.Scan(ctx, ScanOptions{Match: "scan:*", Count: 10}).Batches(func(batch []ScanElement) error {
+			Expect(batch).ToNot(BeEmpty())
+			total += len(batch)
+			return nil
+		})).To(Succeed())
+		Expect(total).To(Equal(50))
+	})
+
+	It("should skip repeated elements", func() {
+		it := service.Scan(ctx, ScanOptions{Deduplicate: true})
+		it.seen["scan:1"] = struct{}{}
+		Expect(service.Set(ctx, "scan:1", "1", 0)).To(Succeed())
+		for it.Next() {
+			Expect(it.Val()).ToNot(Equal("scan:1"))
+		}
+	})
+
+	It("should not keep the elements when not deduplicating", func() {
+		Expect(service.Set(ctx, "scan:1", "1", 0)).To(Succeed())
+		it := service.Scan(ctx, ScanOptions{Match: "scan:*"})
+		Expect(it.Next()).To(BeTrue())
+		Expect(it.seen).To(BeNil())
+		it.Close()
+	})
+
+	It("should stop when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		it := service.Scan(ctx, ScanOptions{})
+		Expect(it.Next()).To(BeFalse())
+		Expect(it.Err()).To(Equal(context.Canceled))
+		Expect(it.conn).To(BeNil())
+	})
+})
@@ -0,0 +1,281 @@
+package redigosrv
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	schedulerPollScriptName = "redigosrv:scheduler:poll"
+	schedulerPollScript     = `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+for _, id in ipairs(ids) do
+	local job = redis.call("HGET", KEYS[2], id)
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("HDEL", KEYS[2], id)
+	if job then
+		redis.call("LPUSH", KEYS[3], job)
+	end
+end
+return #ids
+`
+
+	schedulerCancelScriptName = "redigosrv:scheduler:cancel"
+	schedulerCancelScript     = `
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+return 1
+`
+
+	schedulerRescheduleScriptName = "redigosrv:scheduler:reschedule"
+	schedulerRescheduleScript     = `
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+return 1
+`
+)
+
+// SchedulerOptions are the options of a `Scheduler`.
+type SchedulerOptions struct {
+	// PollInterval is the time between the checks for due jobs. Defaults to
+	// 1 second.
+	PollInterval time.Duration
+	// BatchSize is the maximum number of jobs moved by each check. Defaults
+	// to 100.
+	BatchSize int
+}
+
+// Scheduler keeps jobs in a sorted set by their due time and moves them to
+// the pending list of a `Queue` when they are due. While the service is
+// running, a poller checks for due jobs every poll interval. Due times are
+// compared using the clock of the poller.
+type Scheduler struct {
+	queue *Queue
+	opts  SchedulerOptions
+	// err is the error registering the scripts, returned by `Poll`,
+	// `Cancel` and `Reschedule`.
+	err error
+
+	mu   sync.Mutex
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// schedulerRegistry keeps the schedulers of the service, so their pollers
+// are started and stopped along with it.
+type schedulerRegistry struct {
+	sync.Mutex
+	schedulers []*Scheduler
+}
+
+func (registry *schedulerRegistry) add(scheduler *Scheduler) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.schedulers = append(registry.schedulers, scheduler)
+}
+
+func (registry *schedulerRegistry) remove(scheduler *Scheduler) {
+	registry.Lock()
+	defer registry.Unlock()
+	for i, s := range registry.schedulers {
+		if s == scheduler {
+			registry.schedulers = append(registry.schedulers[:i], registry.schedulers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (registry *schedulerRegistry) startAll() {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, scheduler := range registry.schedulers {
+		scheduler.start()
+	}
+}
+
+func (registry *schedulerRegistry) stopAll() {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, scheduler := range registry.schedulers {
+		scheduler.stop()
+	}
+}
+
+// NewScheduler returns a new `Scheduler` for the queue. Its poller runs while
+// the service is running, until it is closed with `Close`.
+func (service *RedigoService) NewScheduler(queue *Queue, opts SchedulerOptions) *Scheduler {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 100
+	}
+	scheduler := &Scheduler{
+		queue: queue,
+		opts:  opts,
+	}
+	scheduler.err = scheduler.registerScripts()
+	service.schedulers.add(scheduler)
+	if service.isRunning() {
+		scheduler.start()
+	}
+	return scheduler
+}
+
+func (scheduler *Scheduler) dueKey() string {
+	return "scheduler:" + scheduler.queue.name + ":due"
+}
+
+func (scheduler *Scheduler) jobsKey() string {
+	return "scheduler:" + scheduler.queue.name + ":jobs"
+}
+
+func (scheduler *Scheduler) registerScripts() error {
+	service := scheduler.queue.service
+	if _, err := service.registerScript(schedulerPollScriptName, 3, schedulerPollScript); err != nil {
+		return err
+	}
+	if _, err := service.registerScript(schedulerCancelScriptName, 2, schedulerCancelScript); err != nil {
+		return err
+	}
+	_, err := service.registerScript(schedulerRescheduleScriptName, 1, schedulerRescheduleScript)
+	return err
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Schedule adds a job to be enqueued at the given time, returning its ID.
+func (scheduler *Scheduler) Schedule(ctx context.Context, payload []byte, at time.Time) (string, error) {
+	id, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(&Job{ID: id, Payload: payload})
+	if err != nil {
+		return "", err
+	}
+	err = scheduler.queue.service.Transaction(ctx, nil, func(tx *Tx) error {
+		tx.HSet(scheduler.jobsKey(), id, raw)
+		tx.Do("ZADD", scheduler.dueKey(), toMillis(at), id)
+		return nil
+	})
+	return id, err
+}
+
+// ScheduleIn adds a job to be enqueued after the delay, returning its ID.
+func (scheduler *Scheduler) ScheduleIn(ctx context.Context, payload []byte, delay time.Duration) (string, error) {
+	return scheduler.Schedule(ctx, payload, time.Now().Add(delay))
+}
+
+// Cancel removes a scheduled job. It returns `ErrJobNotFound` if the job is
+// not scheduled, because it was already enqueued or cancelled.
+func (scheduler *Scheduler) Cancel(ctx context.Context, id string) error {
+	if scheduler.err != nil {
+		return scheduler.err
+	}
+	n, err := redis.Int(scheduler.queue.service.EvalScript(ctx, schedulerCancelScriptName, scheduler.dueKey(), scheduler.jobsKey(), id))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Reschedule changes when a scheduled job is enqueued. It returns
+// `ErrJobNotFound` if the job is not scheduled.
+func (scheduler *Scheduler) Reschedule(ctx context.Context, id string, at time.Time) error {
+	if scheduler.err != nil {
+		return scheduler.err
+	}
+	n, err := redis.Int(scheduler.queue.service.EvalScript(ctx, schedulerRescheduleScriptName, scheduler.dueKey(), id, toMillis(at)))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Poll moves the due jobs to the pending list of the queue, returning how
+// many were moved. It is called by the poller, but it can be called by any
+// process.
+func (scheduler *Scheduler) Poll(ctx context.Context) (int, error) {
+	if scheduler.err != nil {
+		return 0, scheduler.err
+	}
+	return redis.Int(scheduler.queue.service.EvalScript(ctx, schedulerPollScriptName,
+		scheduler.dueKey(), scheduler.jobsKey(), scheduler.queue.pendingKey(),
+		toMillis(time.Now()), scheduler.opts.BatchSize,
+	))
+}
+
+// Close stops the poller and detaches the scheduler from the service.
+func (scheduler *Scheduler) Close() error {
+	scheduler.queue.service.schedulers.remove(scheduler)
+	scheduler.stop()
+	return nil
+}
+
+func (scheduler *Scheduler) start() {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.done != nil {
+		return
+	}
+	scheduler.done = make(chan struct{})
+	scheduler.wg.Add(1)
+	go scheduler.run(scheduler.done)
+}
+
+func (scheduler *Scheduler) stop() {
+	scheduler.mu.Lock()
+	if scheduler.done != nil {
+		close(scheduler.done)
+		scheduler.done = nil
+	}
+	scheduler.mu.Unlock()
+	scheduler.wg.Wait()
+}
+
+// run polls the due jobs until stopped. While a batch is full, it polls
+// again right away.
+func (scheduler *Scheduler) run(done chan struct{}) {
+	defer scheduler.wg.Done()
+
+	ticker := time.NewTicker(scheduler.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for {
+				n, err := scheduler.Poll(context.Background())
+				if err != nil {
+					scheduler.queue.service.logger().Printf("redigosrv: scheduler %s could not poll: %s", scheduler.queue.name, err)
+				}
+				if err != nil || n < scheduler.opts.BatchSize {
+					break
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
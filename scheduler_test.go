@@ -0,0 +1,118 @@
+package redigosrv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	var service RedigoService
+	var queue *Queue
+	var scheduler *Scheduler
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+
+		for _, pattern := range []string{"queue:delayed:*", "scheduler:delayed:*"} {
+			it := service.Scan(ctx, ScanOptions{Match: pattern})
+			for it.Next() {
+				_, err := service.Del(ctx, it.Val())
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(it.Err()).ToNot(HaveOccurred())
+		}
+
+		queue = service.NewQueue("delayed", QueueOptions{
+			BlockTimeout: 100 * time.Millisecond,
+		})
+		scheduler = service.NewScheduler(queue, SchedulerOptions{
+			PollInterval: time.Hour,
+			BatchSize:    2,
+		})
+	})
+
+	AfterEach(func() {
+		Expect(scheduler.Close()).To(Succeed())
+		service.Stop()
+	})
+
+	It("should move only the due jobs to the queue", func() {
+		id, err := scheduler.Schedule(ctx, []byte("due"), time.Now().Add(-time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = scheduler.ScheduleIn(ctx, []byte("later"), time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(scheduler.Poll(ctx)).To(Equal(1))
+		Expect(scheduler.Poll(ctx)).To(Equal(0))
+
+		job, err := queue.Dequeue(ctx, "w1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.ID).To(Equal(id))
+		Expect(job.Payload).To(Equal([]byte("due")))
+	})
+
+	It("should move the due jobs in batches", func() {
+		for i := 0; i < 3; i++ {
+			_, err := scheduler.Schedule(ctx, []byte("due"), time.Now().Add(-time.Second))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(scheduler.Poll(ctx)).To(Equal(2))
+		Expect(scheduler.Poll(ctx)).To(Equal(1))
+		Expect(queue.Depth(ctx)).To(Equal(&QueueDepth{Pending: 3}))
+	})
+
+	It("should cancel scheduled jobs", func() {
+		id, err := scheduler.Schedule(ctx, []byte("cancelled"), time.Now().Add(-time.Second))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(scheduler.Cancel(ctx, id)).To(Succeed())
+		Expect(scheduler.Cancel(ctx, id)).To(Equal(ErrJobNotFound))
+		Expect(scheduler.Poll(ctx)).To(Equal(0))
+		Expect(service.Exists(ctx, scheduler.jobsKey())).To(Equal(int64(0)))
+	})
+
+	It("should reschedule jobs", func() {
+		id, err := scheduler.ScheduleIn(ctx, []byte("moved"), time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(scheduler.Reschedule(ctx, id, time.Now().Add(-time.Second))).To(Succeed())
+		Expect(scheduler.Poll(ctx)).To(Equal(1))
+		Expect(scheduler.Reschedule(ctx, id, time.Now())).To(Equal(ErrJobNotFound))
+	})
+
+	It("should return the error registering its scripts", func() {
+		var other RedigoService
+		Expect(other.RegisterScript(schedulerCancelScriptName, 2, "return 1")).To(Succeed())
+
+		scheduler := other.NewScheduler(other.NewQueue("delayed", QueueOptions{}), SchedulerOptions{})
+		defer scheduler.Close()
+		_, err := scheduler.Poll(ctx)
+		Expect(err).To(Equal(ErrScriptAlreadyRegistered))
+		Expect(scheduler.Cancel(ctx, "id")).To(Equal(ErrScriptAlreadyRegistered))
+		Expect(scheduler.Reschedule(ctx, "id", time.Now())).To(Equal(ErrScriptAlreadyRegistered))
+	})
+
+	It("should poll while the service is running", func() {
+		poller := service.NewScheduler(queue, SchedulerOptions{
+			PollInterval: 10 * time.Millisecond,
+		})
+		defer poller.Close()
+
+		_, err := poller.ScheduleIn(ctx, []byte("soon"), 20*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() int64 {
+			depth, err := queue.Depth(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			return depth.Pending
+		}).Should(Equal(int64(1)))
+	})
+})
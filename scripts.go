@@ -0,0 +1,155 @@
+package redigosrv
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrScriptNotRegistered is returned by `EvalScript` when no script was
+// registered with the given name.
+var ErrScriptNotRegistered = errors.New("script not registered")
+
+// ErrScriptAlreadyRegistered is returned by `RegisterScript` when a different
+// script was already registered with the same name.
+var ErrScriptAlreadyRegistered = errors.New("script already registered")
+
+// ScriptMetricMethodName is the method label of the script calls metrics.
+const ScriptMetricMethodName = "EvalScript"
+
+// registeredScript is a Lua script registered by name.
+type registeredScript struct {
+	name     string
+	keyCount int
+	src      string
+	hash     string
+}
+
+// args builds the EVAL/EVALSHA arguments.
+func (script *registeredScript) args(spec string, keysAndArgs []interface{}) redis.Args {
+	return redis.Args{spec, script.keyCount}.Add(keysAndArgs...)
+}
+
+type scriptRegistry struct {
+	sync.RWMutex
+	scripts map[string]*registeredScript
+}
+
+func (registry *scriptRegistry) get(name string) (*registeredScript, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	script, ok := registry.scripts[name]
+	return script, ok
+}
+
+func (registry *scriptRegistry) all() []*registeredScript {
+	registry.RLock()
+	defer registry.RUnlock()
+	scripts := make([]*registeredScript, 0, len(registry.scripts))
+	for _, script := range registry.scripts {
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
+// register adds a script returning false if the very same script was already
+// registered.
+func (registry *scriptRegistry) register(script *registeredScript) (bool, error) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if current, ok := registry.scripts[script.name]; ok {
+		if current.hash == script.hash && current.keyCount == script.keyCount {
+			return false, nil
+		}
+		return false, ErrScriptAlreadyRegistered
+	}
+	if registry.scripts == nil {
+		registry.scripts = make(map[string]*registeredScript)
+	}
+	registry.scripts[script.name] = script
+	return true, nil
+}
+
+// loadScripts sends SCRIPT LOAD for the scripts using a pipeline.
+func loadScripts(conn redis.Conn, scripts []*registeredScript) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+	for _, script := range scripts {
+		if err := conn.Send("SCRIPT", "LOAD", script.src); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for range scripts {
+		if _, err := conn.Receive(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RegisterScript registers a Lua script by name. The keyCount is the number of
+// keys the script expects at the beginning of its arguments. Registered
+// scripts are loaded with SCRIPT LOAD on every new connection, so they are
+// available after reconnecting or failing over. If the service is running,
+// the script is loaded right away.
+func (service *RedigoService) RegisterScript(name string, keyCount int, src string) error {
+	added, err := service.registerScript(name, keyCount, src)
+	if err != nil || !added || !service.isRunning() {
+		return err
+	}
+	return service.RunWithConn(func(conn redis.ConnWithTimeout) error {
+		_, err := conn.Do("SCRIPT", "LOAD", src)
+		return err
+	})
+}
+
+// registerScript registers a script as `RegisterScript` does, without
+// loading it right away, returning whether it was not registered yet. It
+// only fails if the name is taken by another script: the script is loaded
+// by the new connections, or sent with EVAL by `EvalScript`.
+func (service *RedigoService) registerScript(name string, keyCount int, src string) (bool, error) {
+	h := sha1.New()
+	h.Write([]byte(src))
+	return service.scripts.register(&registeredScript{
+		name:     name,
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(h.Sum(nil)),
+	})
+}
+
+// EvalScript runs a registered script using EVALSHA. If the server does not
+// know the script (NOSCRIPT), it is sent again using EVAL. The metrics are
+// recorded using the name of the script as the command.
+func (service *RedigoService) EvalScript(ctx context.Context, name string, keysAndArgs ...interface{}) (reply interface{}, err error) {
+	script, ok := service.scripts.get(name)
+	if !ok {
+		return nil, ErrScriptNotRegistered
+	}
+
+	err = service.RunWithConnContext(ctx, func(conn redis.ConnWithTimeout) error {
+		rConn := conn.(*redigoConn)
+		start := time.Now()
+
+		reply, err = doContext(ctx, rConn.conn, "EVALSHA", script.args(script.hash, keysAndArgs)...)
+		if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT ") {
+			reply, err = doContext(ctx, rConn.conn, "EVAL", script.args(script.src, keysAndArgs)...)
+		}
+
+		recordMetrics(rConn.collector, name, ScriptMetricMethodName, time.Since(start).Seconds())
+		return err
+	})
+	return reply, err
+}
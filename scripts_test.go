@@ -0,0 +1,77 @@
+package redigosrv
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const scriptsTestSrc = `return redis.call("INCRBY", KEYS[1], ARGV[1])`
+
+var _ = Describe("RedigoService (Scripts)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		service = RedigoService{}
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should preload the scripts when starting", func() {
+		Expect(service.RegisterScript("incrby", 1, scriptsTestSrc)).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+
+		script, _ := service.scripts.get("incrby")
+		Expect(redis.Ints(service.Do(ctx, "SCRIPT", "EXISTS", script.hash))).To(Equal([]int{1}))
+	})
+
+	It("should evaluate a registered script", func() {
+		Expect(service.Start()).To(Succeed())
+		Expect(service.RegisterScript("incrby", 1, scriptsTestSrc)).To(Succeed())
+		_, err := service.Del(ctx, "scripts-counter")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(redis.Int(service.EvalScript(ctx, "incrby", "scripts-counter", 2))).To(Equal(2))
+		Expect(redis.Int(service.EvalScript(ctx, "incrby", "scripts-counter", 3))).To(Equal(5))
+
+		var metric dto.Metric
+		Expect(service.Collector.commandCalls.With(prometheus.Labels{
+			"method":  ScriptMetricMethodName,
+			"command": "incrby",
+		}).Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(2.0))
+	})
+
+	It("should send the script again when the server does not know it", func() {
+		Expect(service.Start()).To(Succeed())
+		Expect(service.RegisterScript("incrby", 1, scriptsTestSrc)).To(Succeed())
+		_, err := service.Do(ctx, "SCRIPT", "FLUSH")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = service.Del(ctx, "scripts-counter")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(redis.Int(service.EvalScript(ctx, "incrby", "scripts-counter", 2))).To(Equal(2))
+	})
+
+	It("should fail evaluating a script not registered", func() {
+		Expect(service.Start()).To(Succeed())
+		_, err := service.EvalScript(ctx, "unknown")
+		Expect(err).To(Equal(ErrScriptNotRegistered))
+	})
+
+	It("should fail registering a different script with the same name", func() {
+		Expect(service.RegisterScript("incrby", 1, scriptsTestSrc)).To(Succeed())
+		Expect(service.RegisterScript("incrby", 1, scriptsTestSrc)).To(Succeed())
+		Expect(service.RegisterScript("incrby", 1, "return 1")).To(Equal(ErrScriptAlreadyRegistered))
+	})
+})
@@ -1,7 +1,9 @@
 package redigosrv
 
 import (
+	"context"
 	"errors"
+	"log"
 	"strings"
 	"time"
 
@@ -16,6 +18,16 @@ type PubSubConfiguration struct {
 	ReadTimeout         time.Duration `yaml:"read_timeout"`
 	WriteTimeout        time.Duration `yaml:"write_timeout"`
 	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// Durable backs `Publish` and `Subscribe` by streams, for at-least-once
+	// delivery.
+	Durable DurablePubSubConfiguration `yaml:"durable"`
+	// Reconnect is used by `SubscribeWithReconnect` and
+	// `PSubscribeWithReconnect`.
+	Reconnect ReconnectConfiguration `yaml:"reconnect"`
+	// Channel is used by `SubscribeChan`.
+	Channel ChannelConfiguration `yaml:"channel"`
+	// HandlerError is what the subscriptions do when their handler fails.
+	HandlerError HandlerErrorConfiguration `yaml:"handler_error"`
 }
 
 // Configuration is the configuration for the `RedigoService`.
@@ -24,6 +36,31 @@ type Configuration struct {
 	MaxIdle     int                 `yaml:"max_idle"`
 	IdleTimeout time.Duration       `yaml:"idle_timeout"`
 	PubSub      PubSubConfiguration `yaml:"pubsub"`
+	// Namespace prefixes, separated by a colon, the keys of the commands and
+	// the channels, so multiple services can share the same Redis. It is
+	// removed from the keys returned by SCAN, KEYS and RANDOMKEY. Commands
+	// whose keys are unknown fail with `ErrNamespaceUnsupported`.
+	Namespace string `yaml:"namespace"`
+
+	CircuitBreaker CircuitBreakerConfiguration `yaml:"circuit_breaker"`
+	LeakDetection  LeakDetectionConfiguration  `yaml:"leak_detection"`
+	Pipeline       PipelineConfiguration       `yaml:"pipeline"`
+	Transaction    TransactionConfiguration    `yaml:"transaction"`
+	Serialization  SerializationConfiguration  `yaml:"serialization"`
+	Compression    CompressionConfiguration    `yaml:"compression"`
+	Encryption     EncryptionConfiguration     `yaml:"encryption"`
+}
+
+// Logger is used to report problems that cannot be returned to the caller.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger forwards to the standard `log` package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
 }
 
 // RedigoService is the service which manages a Redis connection using the
@@ -32,13 +69,23 @@ type RedigoService struct {
 	redis.Args
 	serviceState
 	pool          *redis.Pool
+	breaker       *circuitBreaker
+	leaks         *leakTracker
+	scripts       scriptRegistry
+	keyring       *keyring
+	schedulers    schedulerRegistry
 	Configuration Configuration
 	Collector     *RedigoCollector
+	// Logger is used to report problems, if not set the standard `log`
+	// package is used.
+	Logger Logger
 }
 
 type redigoConn struct {
 	conn      redis.ConnWithTimeout
 	collector *RedigoCollector
+	leaks     *leakTracker
+	leakID    uint64
 }
 
 // ConnHandler handler redis connection with timeout
@@ -70,6 +117,83 @@ func (service *RedigoService) ApplyConfiguration(configuration interface{}) erro
 	if service.Configuration.PubSub.WriteTimeout == 0 {
 		service.Configuration.PubSub.WriteTimeout = 10 * time.Second
 	}
+	if service.Configuration.PubSub.Reconnect.MinBackoff == 0 {
+		service.Configuration.PubSub.Reconnect.MinBackoff = 100 * time.Millisecond
+	}
+	if service.Configuration.PubSub.Reconnect.MaxBackoff == 0 {
+		service.Configuration.PubSub.Reconnect.MaxBackoff = 30 * time.Second
+	}
+	if service.Configuration.PubSub.Channel.Buffer == 0 {
+		service.Configuration.PubSub.Channel.Buffer = 100
+	}
+	if service.Configuration.PubSub.Channel.Buffer < 0 {
+		return ErrNegativeBuffer
+	}
+	if service.Configuration.PubSub.Channel.Overflow == "" {
+		service.Configuration.PubSub.Channel.Overflow = OverflowBlock
+	}
+	if !service.Configuration.PubSub.Channel.Overflow.valid() {
+		return ErrUnknownOverflowPolicy
+	}
+	if err := service.Configuration.PubSub.HandlerError.apply(); err != nil {
+		return err
+	}
+	if service.Configuration.PubSub.Durable.Consumer == "" {
+		service.Configuration.PubSub.Durable.Consumer = service.Configuration.PubSub.Durable.Subscriber
+	}
+
+	// set defaults for the circuit breaker if not present
+	if service.Configuration.CircuitBreaker.FailureThreshold == 0 {
+		service.Configuration.CircuitBreaker.FailureThreshold = 5
+	}
+	if service.Configuration.CircuitBreaker.OpenTimeout == 0 {
+		service.Configuration.CircuitBreaker.OpenTimeout = 10 * time.Second
+	}
+	if service.Configuration.CircuitBreaker.HalfOpenMaxTrials == 0 {
+		service.Configuration.CircuitBreaker.HalfOpenMaxTrials = 1
+	}
+
+	// set defaults for the leak detection if not present
+	if service.Configuration.LeakDetection.Threshold == 0 {
+		service.Configuration.LeakDetection.Threshold = time.Minute
+	}
+	if service.Configuration.LeakDetection.CheckInterval == 0 {
+		service.Configuration.LeakDetection.CheckInterval = 10 * time.Second
+	}
+
+	// set defaults for pipelines if not present
+	if service.Configuration.Pipeline.ChunkSize == 0 {
+		service.Configuration.Pipeline.ChunkSize = 1000
+	}
+
+	// set defaults for transactions if not present
+	if service.Configuration.Transaction.MaxRetries == 0 {
+		service.Configuration.Transaction.MaxRetries = 3
+	}
+
+	// set defaults for the serialization if not present
+	if service.Configuration.Serialization.Codec == "" {
+		service.Configuration.Serialization.Codec = JSONCodec.Name()
+	}
+	if _, err := CodecByName(service.Configuration.Serialization.Codec); err != nil {
+		return err
+	}
+
+	// set defaults for the compression if not present
+	if service.Configuration.Compression.Threshold == 0 {
+		service.Configuration.Compression.Threshold = 1024
+	}
+	if algorithm := service.Configuration.Compression.Algorithm; algorithm != "" {
+		if _, ok := compressors[algorithm]; !ok {
+			return ErrUnknownCompression
+		}
+	}
+
+	ring, err := newKeyring(service.Configuration.Encryption)
+	if err != nil {
+		return err
+	}
+	service.keyring = ring
 
 	return nil
 }
@@ -104,15 +228,43 @@ func (service *RedigoService) Start() error {
 			return err
 		}
 		service.Collector = NewRedigoCollector(service.pool, RedigoCollectorDefaultOptions())
+		service.breaker = nil
+		if service.Configuration.CircuitBreaker.Enabled {
+			service.breaker = newCircuitBreaker(service.Configuration.CircuitBreaker, service.Collector.setCircuitState)
+		}
+		service.leaks = nil
+		if service.Configuration.LeakDetection.Enabled {
+			service.leaks = newLeakTracker(service.Configuration.LeakDetection, service.logger(), service.Collector)
+			go service.leaks.run()
+		}
 		service.setRunning(true)
+		service.schedulers.startAll()
 	}
 	return nil
 }
 
+// logger returns the configured `Logger` or the standard one.
+func (service *RedigoService) logger() Logger {
+	if service.Logger != nil {
+		return service.Logger
+	}
+	return stdLogger{}
+}
+
 // newConn is used inside of the connection pool definition to create new
-// connections.
+// connections. The registered scripts are loaded in every new connection.
 func (service *RedigoService) newConn() (redis.Conn, error) {
-	return redis.Dial("tcp", service.Configuration.Address)
+	conn, err := redis.Dial("tcp", service.Configuration.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadScripts(conn, service.scripts.all()); err != nil {
+		service.logger().Printf("redigosrv: could not load the scripts: %s", err)
+	}
+	if ns := service.namespace(); ns != "" {
+		return &namespacedConn{Conn: conn, ns: ns}, nil
+	}
+	return conn, nil
 }
 
 // testOnBorrow is used inside of the connection pool definition for testing
@@ -128,10 +280,14 @@ func (service *RedigoService) testOnBorrow(conn redis.Conn, lastUsage time.Time)
 // Stop closes the connection pool.
 func (service *RedigoService) Stop() error {
 	if service.isRunning() {
+		service.schedulers.stopAll()
 		err := service.pool.Close()
 		if err != nil {
 			return err
 		}
+		if service.leaks != nil {
+			service.leaks.stop()
+		}
 		service.setRunning(false)
 	}
 	return nil
@@ -140,13 +296,28 @@ func (service *RedigoService) Stop() error {
 // RunWithConn acquires the connection from a pool ensuring it will be put back
 // after the handler is done.
 func (service *RedigoService) RunWithConn(handler ConnHandler) error {
+	return service.RunWithConnContext(context.Background(), handler)
+}
+
+// RunWithConnContext is the same as `RunWithConn` but it gives up acquiring
+// the connection when the context is done.
+func (service *RedigoService) RunWithConnContext(ctx context.Context, handler ConnHandler) error {
 	if service.isRunning() {
-		conn := service.pool.Get()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := service.breakerAllow(); err != nil {
+			return err
+		}
+		conn, _ := service.pool.GetContext(ctx)
 		if conn.Err() != nil {
+			service.breakerReport(conn.Err())
 			return conn.Err()
 		}
 		defer conn.Close()
-		return handler(&redigoConn{conn: conn.(redis.ConnWithTimeout), collector: service.Collector})
+		err := handler(&redigoConn{conn: conn.(redis.ConnWithTimeout), collector: service.Collector})
+		service.breakerReport(conn.Err())
+		return err
 	}
 
 	return rscsrv.ErrServiceNotRunning
@@ -155,17 +326,29 @@ func (service *RedigoService) RunWithConn(handler ConnHandler) error {
 // GetConn gets a connection from the pool.
 func (service *RedigoService) GetConn() (redis.Conn, error) {
 	if service.isRunning() {
+		if err := service.breakerAllow(); err != nil {
+			return nil, err
+		}
 		conn := service.pool.Get()
+		service.breakerReport(conn.Err())
 		if conn.Err() != nil {
 			return nil, conn.Err()
 		}
-		return &redigoConn{conn: conn.(redis.ConnWithTimeout), collector: service.Collector}, nil
+		rConn := &redigoConn{conn: conn.(redis.ConnWithTimeout), collector: service.Collector}
+		if service.leaks != nil {
+			rConn.leaks = service.leaks
+			rConn.leakID = service.leaks.acquired()
+		}
+		return rConn, nil
 	}
 	return nil, rscsrv.ErrServiceNotRunning
 }
 
 // Close closes the connection.
 func (rConn *redigoConn) Close() error {
+	if rConn.leaks != nil {
+		rConn.leaks.released(rConn.leakID)
+	}
 	return rConn.conn.Close()
 }
 
@@ -210,18 +393,30 @@ func (rConn *redigoConn) Receive() (reply interface{}, err error) {
 // The timeout overrides the read timeout set when dialing the
 // connection.
 func (rConn *redigoConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (reply interface{}, err error) {
-	return rConn.conn.DoWithTimeout(timeout, commandName, args...)
+	start := time.Now()
+	reply, err = rConn.conn.DoWithTimeout(timeout, commandName, args...)
+
+	incrementMetrics(rConn.collector, commandName, "DoWithTimeout", time.Since(start).Seconds())
+	return reply, err
 }
 
 // Receive receives a single reply from the Redis server. The timeout
 // overrides the read timeout set when dialing the connection.
 func (rConn *redigoConn) ReceiveWithTimeout(timeout time.Duration) (reply interface{}, err error) {
-	return rConn.conn.ReceiveWithTimeout(timeout)
+	start := time.Now()
+	reply, err = rConn.conn.ReceiveWithTimeout(timeout)
+
+	incrementMetrics(rConn.collector, "", "ReceiveWithTimeout", time.Since(start).Seconds())
+	return reply, err
 }
 
 func incrementMetrics(collector *RedigoCollector, commandName string, method string, duration float64) {
+	recordMetrics(collector, strings.ToUpper(commandName), method, duration)
+}
 
-	commandName = strings.ToUpper(commandName)
+// recordMetrics is the same as `incrementMetrics` but it does not normalize
+// the command name.
+func recordMetrics(collector *RedigoCollector, commandName string, method string, duration float64) {
 
 	// Total of calls from method
 	collector.commandCalls.With(prometheus.Labels{
@@ -0,0 +1,435 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrStreamConsumerRequired is returned when consuming streams without a
+// group or a consumer name.
+var ErrStreamConsumerRequired = errors.New("stream group and consumer are required")
+
+// streamDataField is the field of the stream entries holding the payload.
+const streamDataField = "data"
+
+// StreamAddOptions are the options of `StreamAdd`.
+type StreamAddOptions struct {
+	// MaxLen trims the stream to this number of entries, if positive.
+	MaxLen int64
+	// MinID trims the entries with IDs lower than this one, if set. Ignored
+	// when MaxLen is set.
+	MinID string
+	// Approximate lets the server trim only whole nodes (`~`), which is
+	// more efficient.
+	Approximate bool
+	// Codec serializes the payload. Defaults to the configured codec.
+	Codec Codec
+}
+
+// StreamHandler is called for each entry of a stream. The entry is
+// acknowledged when it returns no error.
+type StreamHandler func(stream, id string, data []byte) error
+
+// StreamConsumerOptions are the options of `ConsumeStreams`.
+type StreamConsumerOptions struct {
+	// Group is the consumer group, created if it does not exist.
+	Group string
+	// Consumer is the name of this consumer in the group. It should be
+	// kept across restarts, so the entries delivered to it and not
+	// acknowledged are processed again.
+	Consumer string
+	// Start is the ID the group starts from when created. Defaults to `$`,
+	// only the new entries.
+	Start string
+	// Count is the maximum number of entries read by each call. Defaults to
+	// 10.
+	Count int64
+	// Block is how long each read waits for new entries. Defaults to 1
+	// second.
+	Block time.Duration
+	// MinIdle is for how long an entry stays pending on another consumer
+	// before being claimed. Defaults to 30 seconds.
+	MinIdle time.Duration
+	// ClaimInterval is the time between the checks for idle entries, which
+	// also update the lag and pending metrics. Defaults to MinIdle.
+	ClaimInterval time.Duration
+}
+
+func (opts StreamConsumerOptions) withDefaults() StreamConsumerOptions {
+	if opts.Start == "" {
+		opts.Start = "$"
+	}
+	if opts.Count == 0 {
+		opts.Count = 10
+	}
+	if opts.Block == 0 {
+		opts.Block = time.Second
+	}
+	if opts.MinIdle == 0 {
+		opts.MinIdle = 30 * time.Second
+	}
+	if opts.ClaimInterval == 0 {
+		opts.ClaimInterval = opts.MinIdle
+	}
+	return opts
+}
+
+// StreamAdd appends the data payload to the stream with XADD, returning the
+// ID of the entry. Byte slices are sent as they are, any other value is
+// serialized. Both are compressed and encrypted when enabled.
+func (service *RedigoService) StreamAdd(ctx context.Context, stream string, data interface{}, opts StreamAddOptions) (string, error) {
+	var payload []byte
+	var err error
+	if raw, ok := data.([]byte); ok {
+		payload, err = service.wrap(raw)
+	} else {
+		payload, err = service.Marshal(opts.Codec, data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return redis.String(service.Do(ctx, "XADD", streamAddArgs(stream, payload, opts)...))
+}
+
+// streamAddArgs returns the arguments of XADD for the payload.
+func streamAddArgs(stream string, payload []byte, opts StreamAddOptions) redis.Args {
+	args := redis.Args{stream}
+	switch {
+	case opts.MaxLen > 0:
+		args = args.Add("MAXLEN")
+		if opts.Approximate {
+			args = args.Add("~")
+		}
+		args = args.Add(opts.MaxLen)
+	case opts.MinID != "":
+		args = args.Add("MINID")
+		if opts.Approximate {
+			args = args.Add("~")
+		}
+		args = args.Add(opts.MinID)
+	}
+	return args.Add("*", streamDataField, payload)
+}
+
+// streamConsumer keeps the state of `ConsumeStreams`.
+type streamConsumer struct {
+	service    *RedigoService
+	opts       StreamConsumerOptions
+	subscribed SubscribedHandler
+	handler    StreamHandler
+	streams    []string
+	// raw passes the entries to the handler without decrypting and
+	// decompressing them, so it handles the failures.
+	raw bool
+}
+
+// streamEntry is an entry read from a stream. Entries deleted while pending
+// have no fields.
+type streamEntry struct {
+	id      string
+	data    []byte
+	deleted bool
+}
+
+// ConsumeStreams reads the streams as a consumer of a group, calling the
+// handler for each entry and acknowledging it with XACK when handled. The
+// entries delivered to this consumer and not acknowledged, after a restart
+// for example, are processed first. Entries idle for MinIdle on other
+// consumers are claimed with XAUTOCLAIM.
+//
+// It blocks until the context is done, returning nil, or the handler fails,
+// returning its error. The entry that failed stays pending, so it is
+// delivered again.
+func (service *RedigoService) ConsumeStreams(ctx context.Context, opts StreamConsumerOptions, handler StreamHandler, streams ...string) error {
+	return service.consumeStreams(ctx, opts, nil, handler, false, streams...)
+}
+
+// consumeStreams works as `ConsumeStreams`, calling subscribed, if set, when
+// the groups of all the streams exist. If raw, the handler receives the
+// entries as they are stored.
+func (service *RedigoService) consumeStreams(ctx context.Context, opts StreamConsumerOptions, subscribed SubscribedHandler, handler StreamHandler, raw bool, streams ...string) error {
+	if opts.Group == "" || opts.Consumer == "" {
+		return ErrStreamConsumerRequired
+	}
+	consumer := &streamConsumer{
+		service:    service,
+		opts:       opts.withDefaults(),
+		subscribed: subscribed,
+		handler:    handler,
+		streams:    streams,
+		raw:        raw,
+	}
+	err := consumer.run(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (consumer *streamConsumer) run(ctx context.Context) error {
+	for _, stream := range consumer.streams {
+		if err := consumer.createGroup(ctx, stream); err != nil {
+			return err
+		}
+	}
+	if consumer.subscribed != nil {
+		if err := consumer.subscribed(); err != nil {
+			return err
+		}
+	}
+	for _, stream := range consumer.streams {
+		if err := consumer.readPending(ctx, stream); err != nil {
+			return err
+		}
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= consumer.opts.ClaimInterval {
+			lastClaim = time.Now()
+			for _, stream := range consumer.streams {
+				if err := consumer.claim(ctx, stream); err != nil {
+					return err
+				}
+				if err := consumer.updateMetrics(ctx, stream); err != nil {
+					return err
+				}
+			}
+		}
+		if err := consumer.read(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createGroup creates the group, if it does not exist yet, creating the
+// stream too.
+func (consumer *streamConsumer) createGroup(ctx context.Context, stream string) error {
+	_, err := consumer.service.Do(ctx, "XGROUP", "CREATE", stream, consumer.opts.Group, consumer.opts.Start, "MKSTREAM")
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// readPending processes the entries delivered to this consumer and not
+// acknowledged.
+func (consumer *streamConsumer) readPending(ctx context.Context, stream string) error {
+	id := "0"
+	for {
+		reply, err := consumer.service.Do(ctx, "XREADGROUP", "GROUP", consumer.opts.Group, consumer.opts.Consumer,
+			"COUNT", consumer.opts.Count, "STREAMS", stream, id)
+		if err != nil {
+			return err
+		}
+		entries, err := streamEntries(reply, stream)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		if err := consumer.dispatch(ctx, stream, entries); err != nil {
+			return err
+		}
+		if id = entries[len(entries)-1].id; id == "" {
+			return nil
+		}
+	}
+}
+
+// read waits for new entries on all the streams.
+func (consumer *streamConsumer) read(ctx context.Context) error {
+	args := redis.Args{"GROUP", consumer.opts.Group, consumer.opts.Consumer,
+		"COUNT", consumer.opts.Count,
+		"BLOCK", int64(consumer.opts.Block / time.Millisecond),
+		"STREAMS",
+	}
+	args = args.AddFlat(consumer.streams)
+	for range consumer.streams {
+		args = args.Add(">")
+	}
+	reply, err := consumer.service.Do(ctx, "XREADGROUP", args...)
+	if err != nil || reply == nil {
+		// The reply is nil when nothing new arrives before the block
+		// timeout.
+		return err
+	}
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return err
+	}
+	for _, s := range streams {
+		values, err := redis.Values(s, nil)
+		if err != nil {
+			return err
+		}
+		if len(values) != 2 {
+			continue
+		}
+		stream, err := redis.String(values[0], nil)
+		if err != nil {
+			return err
+		}
+		entries, err := parseStreamEntries(values[1])
+		if err != nil {
+			return err
+		}
+		if err := consumer.dispatch(ctx, stream, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claim takes the entries idle for MinIdle on any consumer of the group.
+func (consumer *streamConsumer) claim(ctx context.Context, stream string) error {
+	minIdle := int64(consumer.opts.MinIdle / time.Millisecond)
+	cursor := "0-0"
+	for {
+		values, err := redis.Values(consumer.service.Do(ctx, "XAUTOCLAIM", stream, consumer.opts.Group, consumer.opts.Consumer,
+			minIdle, cursor, "COUNT", consumer.opts.Count))
+		if err != nil {
+			return err
+		}
+		if len(values) < 2 {
+			return nil
+		}
+		if cursor, err = redis.String(values[0], nil); err != nil {
+			return err
+		}
+		entries, err := parseStreamEntries(values[1])
+		if err != nil {
+			return err
+		}
+		if err := consumer.dispatch(ctx, stream, entries); err != nil {
+			return err
+		}
+		if cursor == "0-0" {
+			return nil
+		}
+	}
+}
+
+// dispatch calls the handler for each entry, acknowledging it when handled.
+// Entries deleted while pending are only acknowledged.
+func (consumer *streamConsumer) dispatch(ctx context.Context, stream string, entries []streamEntry) error {
+	for _, entry := range entries {
+		if !entry.deleted {
+			data, err := entry.data, error(nil)
+			if !consumer.raw {
+				data, err = consumer.service.unwrap(entry.data)
+			}
+			if err == nil {
+				err = consumer.handler(stream, entry.id, data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if entry.id == "" {
+			continue
+		}
+		// Handled entries are acknowledged even if the consumer is stopping.
+		if _, err := consumer.service.Do(context.Background(), "XACK", stream, consumer.opts.Group, entry.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMetrics sets the lag and pending metrics of the group. The lag is
+// only reported by Redis 7 or newer.
+func (consumer *streamConsumer) updateMetrics(ctx context.Context, stream string) error {
+	groups, err := redis.Values(consumer.service.Do(ctx, "XINFO", "GROUPS", stream))
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		info, err := redis.Values(group, nil)
+		if err != nil {
+			return err
+		}
+		fields := make(map[string]interface{}, len(info)/2)
+		for i := 0; i+1 < len(info); i += 2 {
+			name, _ := redis.String(info[i], nil)
+			fields[name] = info[i+1]
+		}
+		if name, _ := redis.String(fields["name"], nil); name != consumer.opts.Group {
+			continue
+		}
+
+		labels := prometheus.Labels{"stream": stream, "group": consumer.opts.Group}
+		if pending, err := redis.Int64(fields["pending"], nil); err == nil {
+			consumer.service.Collector.streamPending.With(labels).Set(float64(pending))
+		}
+		if lag, err := redis.Int64(fields["lag"], nil); err == nil {
+			consumer.service.Collector.streamLag.With(labels).Set(float64(lag))
+		}
+	}
+	return nil
+}
+
+// streamEntries returns the entries of the stream in a XREADGROUP reply.
+func streamEntries(reply interface{}, stream string) ([]streamEntry, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range streams {
+		values, err := redis.Values(s, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			continue
+		}
+		if name, _ := redis.String(values[0], nil); name == stream {
+			return parseStreamEntries(values[1])
+		}
+	}
+	return nil, nil
+}
+
+// parseStreamEntries parses a list of entries, each one an ID and its list
+// of fields and values.
+func parseStreamEntries(reply interface{}) ([]streamEntry, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]streamEntry, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			// Entries deleted while pending, returned by Redis 6.2.
+			entries = append(entries, streamEntry{deleted: true})
+			continue
+		}
+		parts, err := redis.Values(value, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			continue
+		}
+		entry := streamEntry{deleted: parts[1] == nil}
+		if entry.id, err = redis.String(parts[0], nil); err != nil {
+			return nil, err
+		}
+		fields, _ := redis.Values(parts[1], nil)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if name, _ := redis.String(fields[i], nil); name == streamDataField {
+				entry.data, _ = redis.Bytes(fields[i+1], nil)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
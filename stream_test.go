@@ -0,0 +1,147 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Streams)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+
+		_, err := service.Del(ctx, "stream-01", "stream-02")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	pending := func(stream, group string) int64 {
+		values, err := service.Do(ctx, "XPENDING", stream, group)
+		Expect(err).ToNot(HaveOccurred())
+		return values.([]interface{})[0].(int64)
+	}
+
+	It("should add entries trimming the stream", func() {
+		var ids []string
+		for i := 0; i < 5; i++ {
+			id, err := service.StreamAdd(ctx, "stream-01", i, StreamAddOptions{MaxLen: 3})
+			Expect(err).ToNot(HaveOccurred())
+			ids = append(ids, id)
+		}
+		Expect(service.Do(ctx, "XLEN", "stream-01")).To(Equal(int64(3)))
+
+		_, err := service.StreamAdd(ctx, "stream-01", 5, StreamAddOptions{MinID: ids[4]})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(service.Do(ctx, "XLEN", "stream-01")).To(Equal(int64(2)))
+	})
+
+	It("should require the group and the consumer", func() {
+		Expect(service.ConsumeStreams(ctx, StreamConsumerOptions{Group: "group"}, nil, "stream-01")).To(Equal(ErrStreamConsumerRequired))
+	})
+
+	It("should consume and acknowledge entries from many streams", func(done Done) {
+		first, err := service.StreamAdd(ctx, "stream-01", "first", StreamAddOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		second, err := service.StreamAdd(ctx, "stream-02", []byte("second"), StreamAddOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		consumeCtx, cancel := context.WithCancel(ctx)
+		received := map[string]string{}
+		Expect(service.ConsumeStreams(consumeCtx, StreamConsumerOptions{
+			Group:    "group",
+			Consumer: "c1",
+			Start:    "0",
+			Block:    50 * time.Millisecond,
+		}, func(stream, id string, data []byte) error {
+			received[stream+"/"+id] = string(data)
+			if len(received) == 2 {
+				cancel()
+			}
+			return nil
+		}, "stream-01", "stream-02")).To(Succeed())
+
+		Expect(received).To(Equal(map[string]string{
+			"stream-01/" + first:  "\"first\"",
+			"stream-02/" + second: "second",
+		}))
+		Expect(pending("stream-01", "group")).To(Equal(int64(0)))
+		Expect(pending("stream-02", "group")).To(Equal(int64(0)))
+
+		var metric dto.Metric
+		Expect(service.Collector.streamPending.With(prometheus.Labels{"stream": "stream-01", "group": "group"}).Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(0)))
+
+		close(done)
+	})
+
+	It("should deliver again the entries that failed after a restart", func(done Done) {
+		id, err := service.StreamAdd(ctx, "stream-01", []byte("failing"), StreamAddOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		opts := StreamConsumerOptions{
+			Group:    "group",
+			Consumer: "c1",
+			Start:    "0",
+			Block:    50 * time.Millisecond,
+		}
+		failure := errors.New("failed")
+		Expect(service.ConsumeStreams(ctx, opts, func(stream, entryID string, data []byte) error {
+			return failure
+		}, "stream-01")).To(Equal(failure))
+		Expect(pending("stream-01", "group")).To(Equal(int64(1)))
+
+		consumeCtx, cancel := context.WithCancel(ctx)
+		Expect(service.ConsumeStreams(consumeCtx, opts, func(stream, entryID string, data []byte) error {
+			Expect(entryID).To(Equal(id))
+			Expect(data).To(Equal([]byte("failing")))
+			cancel()
+			return nil
+		}, "stream-01")).To(Succeed())
+		Expect(pending("stream-01", "group")).To(Equal(int64(0)))
+
+		close(done)
+	})
+
+	It("should claim the entries idle on other consumers", func(done Done) {
+		id, err := service.StreamAdd(ctx, "stream-01", []byte("abandoned"), StreamAddOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		opts := StreamConsumerOptions{
+			Group:    "group",
+			Consumer: "c1",
+			Start:    "0",
+			Block:    50 * time.Millisecond,
+			MinIdle:  50 * time.Millisecond,
+		}
+		Expect(service.ConsumeStreams(ctx, opts, func(stream, entryID string, data []byte) error {
+			return errors.New("crashed")
+		}, "stream-01")).To(HaveOccurred())
+
+		time.Sleep(100 * time.Millisecond)
+
+		opts.Consumer = "c2"
+		consumeCtx, cancel := context.WithCancel(ctx)
+		Expect(service.ConsumeStreams(consumeCtx, opts, func(stream, entryID string, data []byte) error {
+			Expect(entryID).To(Equal(id))
+			cancel()
+			return nil
+		}, "stream-01")).To(Succeed())
+		Expect(pending("stream-01", "group")).To(Equal(int64(0)))
+
+		close(done)
+	})
+})
@@ -0,0 +1,506 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrSubscriberClosed is returned when adding listeners to a closed
+// `Subscriber`.
+var ErrSubscriberClosed = errors.New("subscriber closed")
+
+// ErrHandlerPanicked is the error of a `Subscriber` handler that panicked.
+var ErrHandlerPanicked = errors.New("subscriber handler panicked")
+
+// SubscriberOptions are the options of a `Subscriber`.
+type SubscriberOptions struct {
+	// Connections is the number of connections the channels are spread
+	// over. Defaults to 1.
+	Connections int
+	// Buffer is the number of messages of each connection waiting for the
+	// handlers. Defaults to the configured `ChannelConfiguration`.
+	Buffer int
+	// Overflow is the policy when the buffer is full. Defaults to the
+	// configured `ChannelConfiguration`. With "block", a handler adding
+	// channels while the buffer is full waits until its context is done.
+	Overflow OverflowPolicy
+}
+
+// Subscriber keeps long-lived subscriptions whose channels can be added and
+// removed at runtime. The channels are spread over a few connections, which
+// reconnect as `SubscribeWithReconnect` does, and subscribed while at least
+// one listener is added to them.
+type Subscriber struct {
+	service *RedigoService
+	conns   []*subscriberConn
+
+	// mu guards the listeners and the writes to the connections.
+	mu        sync.Mutex
+	listeners map[string]map[*Listener]struct{}
+	ready     map[string]chan struct{}
+	closed    bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	// err is the error of the options, returned by `Add`.
+	err error
+}
+
+// Listener is a handler added to a `Subscriber`, used to remove it.
+type Listener struct {
+	handler  SubscriptionHandler
+	channels map[string]struct{}
+}
+
+// subscriberConn is one of the connections of a `Subscriber`.
+type subscriberConn struct {
+	subscriber *Subscriber
+	conn       redis.Conn
+	count      int32
+
+	// The messages received wait in the queue for their handlers, which run
+	// apart from the receive loop so they can add listeners.
+	queue    chan subscriberMessage
+	overflow OverflowPolicy
+}
+
+// subscriberMessage is a message received by a `subscriberConn`.
+type subscriberMessage struct {
+	channel string
+	data    []byte
+}
+
+// NewSubscriber returns a new `Subscriber`, that must be closed with
+// `Close`. With invalid options, its `Add` fails with `ErrNegativeBuffer` or
+// `ErrUnknownOverflowPolicy`.
+func (service *RedigoService) NewSubscriber(opts SubscriberOptions) *Subscriber {
+	if opts.Connections <= 0 {
+		opts.Connections = 1
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = service.Configuration.PubSub.Channel.Buffer
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = service.Configuration.PubSub.Channel.Overflow
+	}
+	subscriber := &Subscriber{
+		service:   service,
+		listeners: make(map[string]map[*Listener]struct{}),
+		ready:     make(map[string]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	switch {
+	case opts.Buffer < 0:
+		subscriber.err = ErrNegativeBuffer
+	case !opts.Overflow.valid():
+		subscriber.err = ErrUnknownOverflowPolicy
+	}
+	if subscriber.err != nil {
+		subscriber.closed = true
+		close(subscriber.done)
+		return subscriber
+	}
+
+	for i := 0; i < opts.Connections; i++ {
+		conn := &subscriberConn{
+			subscriber: subscriber,
+			queue:      make(chan subscriberMessage, opts.Buffer),
+			overflow:   opts.Overflow,
+		}
+		subscriber.conns = append(subscriber.conns, conn)
+		subscriber.wg.Add(2)
+		go conn.run()
+		go conn.dispatch()
+	}
+	subscriber.wg.Add(1)
+	go subscriber.healthCheck()
+	return subscriber
+}
+
+// connFor returns the connection a channel is subscribed on.
+func (subscriber *Subscriber) connFor(channel string) *subscriberConn {
+	h := fnv.New32a()
+	h.Write([]byte(channel))
+	return subscriber.conns[h.Sum32()%uint32(len(subscriber.conns))]
+}
+
+// Add adds a listener to the channels, subscribing the channels that had no
+// listeners. It returns when all the channels are subscribed, or the
+// context is done. It can be called from the handlers.
+func (subscriber *Subscriber) Add(ctx context.Context, handler SubscriptionHandler, channels ...string) (*Listener, error) {
+	listener := &Listener{
+		handler:  handler,
+		channels: make(map[string]struct{}, len(channels)),
+	}
+
+	subscriber.mu.Lock()
+	if subscriber.err != nil {
+		subscriber.mu.Unlock()
+		return nil, subscriber.err
+	}
+	if subscriber.closed {
+		subscriber.mu.Unlock()
+		return nil, ErrSubscriberClosed
+	}
+	added := make(map[*subscriberConn][]string)
+	ready := make([]chan struct{}, 0, len(channels))
+	for _, channel := range channels {
+		listener.channels[channel] = struct{}{}
+		if subscriber.listeners[channel] == nil {
+			subscriber.listeners[channel] = make(map[*Listener]struct{})
+			subscriber.ready[channel] = make(chan struct{})
+			conn := subscriber.connFor(channel)
+			added[conn] = append(added[conn], channel)
+		}
+		subscriber.listeners[channel][listener] = struct{}{}
+		ready = append(ready, subscriber.ready[channel])
+	}
+	for conn, channels := range added {
+		conn.send("SUBSCRIBE", channels)
+	}
+	subscriber.mu.Unlock()
+
+	for _, r := range ready {
+		select {
+		case <-r:
+		case <-ctx.Done():
+			subscriber.Remove(listener)
+			return nil, ctx.Err()
+		}
+	}
+	return listener, nil
+}
+
+// Remove removes the listener from the channels, or from all its channels
+// if none is given. The channels left with no listeners are unsubscribed.
+func (subscriber *Subscriber) Remove(listener *Listener, channels ...string) {
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+
+	if len(channels) == 0 {
+		for channel := range listener.channels {
+			channels = append(channels, channel)
+		}
+	}
+	removed := make(map[*subscriberConn][]string)
+	for _, channel := range channels {
+		if _, ok := listener.channels[channel]; !ok {
+			continue
+		}
+		delete(listener.channels, channel)
+		delete(subscriber.listeners[channel], listener)
+		if len(subscriber.listeners[channel]) == 0 {
+			delete(subscriber.listeners, channel)
+			delete(subscriber.ready, channel)
+			conn := subscriber.connFor(channel)
+			removed[conn] = append(removed[conn], channel)
+		}
+	}
+	if subscriber.closed {
+		return
+	}
+	for conn, channels := range removed {
+		conn.send("UNSUBSCRIBE", channels)
+	}
+}
+
+// Channels returns the channels with listeners.
+func (subscriber *Subscriber) Channels() []string {
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+	channels := make([]string, 0, len(subscriber.listeners))
+	for channel := range subscriber.listeners {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// Close closes the connections, waiting for the handlers running.
+func (subscriber *Subscriber) Close() error {
+	subscriber.mu.Lock()
+	if subscriber.closed {
+		subscriber.mu.Unlock()
+		return nil
+	}
+	subscriber.closed = true
+	close(subscriber.done)
+	for _, conn := range subscriber.conns {
+		if conn.conn != nil {
+			conn.conn.Close()
+		}
+	}
+	subscriber.mu.Unlock()
+
+	subscriber.wg.Wait()
+	return nil
+}
+
+// dispatch calls the listeners of the channel applying the handler error
+// policy.
+func (subscriber *Subscriber) dispatch(channel string, data []byte) {
+	subscriber.mu.Lock()
+	listeners := make([]*Listener, 0, len(subscriber.listeners[channel]))
+	for listener := range subscriber.listeners[channel] {
+		listeners = append(listeners, listener)
+	}
+	subscriber.mu.Unlock()
+
+	raw := data
+	data, unwrapErr := subscriber.service.unwrap(raw)
+	if unwrapErr != nil {
+		data = raw
+	}
+	for _, listener := range listeners {
+		err := subscriber.service.handleMessage(context.Background(), channel, data, func() (err error) {
+			if unwrapErr != nil {
+				return unwrapErr
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					subscriber.service.logger().Printf("redigosrv: subscriber handler panicked on %s: %v", channel, r)
+					err = ErrHandlerPanicked
+				}
+			}()
+			return listener.handler(channel, data)
+		})
+		if err != nil {
+			// The subscriber is never stopped.
+			subscriber.service.logger().Printf("redigosrv: subscriber handler failed on %s: %s", channel, err)
+		}
+	}
+}
+
+// healthCheck pings the subscribed connections, so the failures are
+// noticed by their read timeout.
+func (subscriber *Subscriber) healthCheck() {
+	defer subscriber.wg.Done()
+
+	ticker := time.NewTicker(subscriber.service.Configuration.PubSub.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			subscriber.mu.Lock()
+			for _, conn := range subscriber.conns {
+				// PING replies as a pubsub message only while subscribed.
+				if atomic.LoadInt32(&conn.count) > 0 {
+					conn.send("PING", nil)
+				}
+			}
+			subscriber.mu.Unlock()
+		case <-subscriber.done:
+			return
+		}
+	}
+}
+
+// send sends a command with the channels, closing the connection if it
+// fails so it reconnects. Disconnected connections subscribe to their
+// channels when connected. The subscriber lock must be held.
+func (conn *subscriberConn) send(command string, channels []string) {
+	if conn.conn == nil {
+		return
+	}
+	ns := conn.subscriber.service.namespace()
+	args := redis.Args{}
+	for _, channel := range channels {
+		args = args.Add(ns.key(channel))
+	}
+	conn.conn.Send(command, args...)
+	if err := conn.conn.Flush(); err != nil {
+		conn.conn.Close()
+	}
+}
+
+// run connects and receives the messages until the subscriber is closed.
+func (conn *subscriberConn) run() {
+	defer conn.subscriber.wg.Done()
+
+	service := conn.subscriber.service
+	config := service.Configuration.PubSub.Reconnect
+	backoff := newBackoff(config.MinBackoff, config.MaxBackoff)
+	connected := false
+	for {
+		c, err := conn.connect()
+		if err == nil {
+			if connected {
+				// Increment to count reconnections
+				service.Collector.subscribeReconnects.Inc()
+			}
+			connected = true
+			backoff.reset()
+			err = conn.receive(c)
+			conn.disconnect(c)
+		}
+
+		select {
+		case <-conn.subscriber.done:
+			return
+		default:
+		}
+
+		delay := backoff.next()
+		service.logger().Printf("redigosrv: subscriber connection lost, reconnecting in %s: %s", delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-conn.subscriber.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// enqueue queues a message received for its handlers applying the overflow
+// policy. Only the receive loop enqueues, so dropping the oldest message
+// always makes room.
+func (conn *subscriberConn) enqueue(channel string, data []byte) {
+	collector := conn.subscriber.service.Collector
+	msg := subscriberMessage{channel: channel, data: data}
+
+	// Increment 1 in subscriberQueueDepth
+	collector.subscriberQueueDepth.Inc()
+
+	switch conn.overflow {
+	case OverflowBlock:
+		select {
+		case conn.queue <- msg:
+		case <-conn.subscriber.done:
+			// Decrement 1 in subscriberQueueDepth
+			collector.subscriberQueueDepth.Dec()
+		}
+	case OverflowDropNewest:
+		select {
+		case conn.queue <- msg:
+		default:
+			// Increment to count dropped messages
+			collector.subscribeDropped.Inc()
+			// Decrement 1 in subscriberQueueDepth
+			collector.subscriberQueueDepth.Dec()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case conn.queue <- msg:
+				return
+			default:
+			}
+			select {
+			case <-conn.queue:
+				// Increment to count dropped messages
+				collector.subscribeDropped.Inc()
+				// Decrement 1 in subscriberQueueDepth
+				collector.subscriberQueueDepth.Dec()
+			default:
+			}
+		}
+	}
+}
+
+// dispatch calls the handlers of the messages queued, in the order they were
+// received, until the subscriber is closed.
+func (conn *subscriberConn) dispatch() {
+	defer conn.subscriber.wg.Done()
+
+	collector := conn.subscriber.service.Collector
+	for {
+		select {
+		case msg := <-conn.queue:
+			// Decrement 1 in subscriberQueueDepth
+			collector.subscriberQueueDepth.Dec()
+
+			conn.subscriber.dispatch(msg.channel, msg.data)
+		case <-conn.subscriber.done:
+			return
+		}
+	}
+}
+
+// connect dials and subscribes to the channels of the connection.
+func (conn *subscriberConn) connect() (redis.Conn, error) {
+	service := conn.subscriber.service
+	c, err := redis.Dial("tcp", service.Configuration.Address,
+		// Read timeout on server should be greater than ping period.
+		redis.DialReadTimeout(service.Configuration.PubSub.ReadTimeout),
+		redis.DialWriteTimeout(service.Configuration.PubSub.WriteTimeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber := conn.subscriber
+	subscriber.mu.Lock()
+	defer subscriber.mu.Unlock()
+	if subscriber.closed {
+		c.Close()
+		return nil, ErrSubscriberClosed
+	}
+	conn.conn = c
+	var channels []string
+	for channel := range subscriber.listeners {
+		if subscriber.connFor(channel) == conn {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) > 0 {
+		conn.send("SUBSCRIBE", channels)
+	}
+
+	// Increment 1 in subscriptionsActive
+	service.Collector.subscriptionsActive.Inc()
+	return c, nil
+}
+
+func (conn *subscriberConn) disconnect(c redis.Conn) {
+	conn.subscriber.mu.Lock()
+	conn.conn = nil
+	conn.subscriber.mu.Unlock()
+	atomic.StoreInt32(&conn.count, 0)
+	c.Close()
+
+	// Decrement 1 in subscriptionsActive
+	conn.subscriber.service.Collector.subscriptionsActive.Dec()
+}
+
+// receive dispatches the messages received until the connection fails.
+func (conn *subscriberConn) receive(c redis.Conn) error {
+	ns := conn.subscriber.service.namespace()
+	psc := redis.PubSubConn{Conn: c}
+	for {
+		// While not subscribed there is no health check, so there is no
+		// read timeout.
+		var n interface{}
+		if atomic.LoadInt32(&conn.count) > 0 {
+			n = psc.Receive()
+		} else {
+			n = psc.ReceiveWithTimeout(0)
+		}
+
+		switch n := n.(type) {
+		case error:
+			return n
+		case redis.Message:
+			conn.enqueue(ns.strip(n.Channel), n.Data)
+		case redis.Subscription:
+			atomic.StoreInt32(&conn.count, int32(n.Count))
+			if n.Kind != "subscribe" {
+				continue
+			}
+			conn.subscriber.mu.Lock()
+			if ready, ok := conn.subscriber.ready[ns.strip(n.Channel)]; ok {
+				select {
+				case <-ready:
+				default:
+					close(ready)
+				}
+			}
+			conn.subscriber.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package redigosrv
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Subscriber", func() {
+	var service RedigoService
+	var subscriber *Subscriber
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			PubSub: PubSubConfiguration{
+				Reconnect: ReconnectConfiguration{
+					MinBackoff: 10 * time.Millisecond,
+				},
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		subscriber = service.NewSubscriber(SubscriberOptions{Connections: 3})
+	})
+
+	AfterEach(func() {
+		Expect(subscriber.Close()).To(Succeed())
+		service.Stop()
+	})
+
+	received := func(messages chan string) SubscriptionHandler {
+		return func(channel string, data []byte) error {
+			messages <- channel + "|" + string(data)
+			return nil
+		}
+	}
+
+	numSub := func(channel string) int64 {
+		values, err := redis.Values(service.Do(ctx, "PUBSUB", "NUMSUB", channel))
+		Expect(err).ToNot(HaveOccurred())
+		return values[1].(int64)
+	}
+
+	It("should count the listeners of each channel", func() {
+		first, second := make(chan string, 10), make(chan string, 10)
+		l1, err := subscriber.Add(ctx, received(first), "sub-01")
+		Expect(err).ToNot(HaveOccurred())
+		l2, err := subscriber.Add(ctx, received(second), "sub-01", "sub-02")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(subscriber.Channels()).To(ConsistOf("sub-01", "sub-02"))
+
+		Expect(service.Publish(ctx, "sub-01", []byte("both"))).To(Succeed())
+		Eventually(first).Should(Receive(Equal("sub-01|both")))
+		Eventually(second).Should(Receive(Equal("sub-01|both")))
+
+		subscriber.Remove(l2, "sub-01")
+		Expect(subscriber.Channels()).To(ConsistOf("sub-01", "sub-02"))
+		Expect(service.Publish(ctx, "sub-01", []byte("first"))).To(Succeed())
+		Expect(service.Publish(ctx, "sub-02", []byte("second"))).To(Succeed())
+		Eventually(first).Should(Receive(Equal("sub-01|first")))
+		Eventually(second).Should(Receive(Equal("sub-02|second")))
+		Consistently(second, "50ms").ShouldNot(Receive())
+
+		subscriber.Remove(l1)
+		subscriber.Remove(l2)
+		Expect(subscriber.Channels()).To(BeEmpty())
+		Eventually(func() int64 { return numSub("sub-01") }).Should(Equal(int64(0)))
+		Eventually(func() int64 { return numSub("sub-02") }).Should(Equal(int64(0)))
+	})
+
+	It("should spread the channels over the connections", func() {
+		messages := make(chan string, 20)
+		var channels []string
+		for i := 0; i < 10; i++ {
+			channels = append(channels, fmt.Sprintf("sub-%02d", i))
+		}
+		_, err := subscriber.Add(ctx, received(messages), channels...)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, channel := range channels {
+			Expect(service.Publish(ctx, channel, []byte("spread"))).To(Succeed())
+		}
+		for range channels {
+			Eventually(messages).Should(Receive(HaveSuffix("|spread")))
+		}
+
+		var used int
+		for _, conn := range subscriber.conns {
+			if atomic.LoadInt32(&conn.count) > 0 {
+				used++
+			}
+		}
+		Expect(used).To(BeNumerically(">", 1))
+	})
+
+	It("should subscribe again when the connection fails", func() {
+		messages := make(chan string, 10)
+		_, err := subscriber.Add(ctx, received(messages), "sub-01")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = service.Do(ctx, "CLIENT", "KILL", "TYPE", "pubsub")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() string {
+			Expect(service.Publish(ctx, "sub-01", []byte("again"))).To(Succeed())
+			select {
+			case message := <-messages:
+				return message
+			case <-time.After(20 * time.Millisecond):
+				return ""
+			}
+		}).Should(Equal("sub-01|again"))
+
+		var metric dto.Metric
+		Expect(service.Collector.subscribeReconnects.Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(BeNumerically(">=", 1))
+	})
+
+	It("should add listeners from the handlers", func() {
+		// The channel added is on the connection of the handler.
+		added := ""
+		for i := 2; added == ""; i++ {
+			if channel := fmt.Sprintf("sub-%02d", i); subscriber.connFor(channel) == subscriber.connFor("sub-01") {
+				added = channel
+			}
+		}
+
+		messages := make(chan string, 10)
+		errs := make(chan error, 1)
+		_, err := subscriber.Add(ctx, func(channel string, data []byte) error {
+			addCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			_, err := subscriber.Add(addCtx, received(messages), added)
+			errs <- err
+			return err
+		}, "sub-01")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(service.Publish(ctx, "sub-01", []byte("add"))).To(Succeed())
+		Eventually(errs).Should(Receive(BeNil()))
+		Expect(service.Publish(ctx, added, []byte("added"))).To(Succeed())
+		Eventually(messages).Should(Receive(Equal(added + "|added")))
+	})
+
+	It("should bound the messages waiting for the handlers", func() {
+		bounded := service.NewSubscriber(SubscriberOptions{Buffer: 1, Overflow: OverflowDropNewest})
+		defer bounded.Close()
+
+		started, release := make(chan struct{}, 10), make(chan struct{})
+		messages := make(chan string, 10)
+		_, err := bounded.Add(ctx, func(channel string, data []byte) error {
+			started <- struct{}{}
+			<-release
+			messages <- string(data)
+			return nil
+		}, "sub-01")
+		Expect(err).ToNot(HaveOccurred())
+
+		// The first message is handled, the second waits and the third is
+		// dropped.
+		Expect(service.Publish(ctx, "sub-01", []byte("1"))).To(Succeed())
+		Eventually(started).Should(Receive())
+		for _, data := range []string{"2", "3"} {
+			Expect(service.Publish(ctx, "sub-01", []byte(data))).To(Succeed())
+		}
+		var metric dto.Metric
+		Eventually(func() float64 {
+			Expect(service.Collector.subscribeDropped.Write(&metric)).To(Succeed())
+			return metric.GetCounter().GetValue()
+		}).Should(Equal(float64(1)))
+		Expect(service.Collector.subscriberQueueDepth.Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(1)))
+
+		close(release)
+		Eventually(messages).Should(Receive(Equal("1")))
+		Eventually(messages).Should(Receive(Equal("2")))
+		Consistently(messages, "50ms").ShouldNot(Receive())
+		Expect(service.Collector.subscriberQueueDepth.Write(&metric)).To(Succeed())
+		Expect(metric.GetGauge().GetValue()).To(Equal(float64(0)))
+	})
+
+	It("should keep dispatching when a handler panics", func() {
+		messages := make(chan string, 10)
+		_, err := subscriber.Add(ctx, func(channel string, data []byte) error {
+			if string(data) == "panic" {
+				panic("something bad")
+			}
+			messages <- string(data)
+			return nil
+		}, "sub-01")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(service.Publish(ctx, "sub-01", []byte("panic"))).To(Succeed())
+		Expect(service.Publish(ctx, "sub-01", []byte("after"))).To(Succeed())
+		Eventually(messages).Should(Receive(Equal("after")))
+	})
+
+	It("should fail to add listeners with invalid options", func() {
+		invalid := service.NewSubscriber(SubscriberOptions{Buffer: -1})
+		_, err := invalid.Add(ctx, received(make(chan string)), "sub-01")
+		Expect(err).To(Equal(ErrNegativeBuffer))
+		Expect(invalid.Close()).To(Succeed())
+
+		invalid = service.NewSubscriber(SubscriberOptions{Overflow: "unknown"})
+		_, err = invalid.Add(ctx, received(make(chan string)), "sub-01")
+		Expect(err).To(Equal(ErrUnknownOverflowPolicy))
+		Expect(invalid.Close()).To(Succeed())
+	})
+
+	It("should not add listeners when closed", func() {
+		Expect(subscriber.Close()).To(Succeed())
+		_, err := subscriber.Add(ctx, received(make(chan string)), "sub-01")
+		Expect(err).To(Equal(ErrSubscriberClosed))
+	})
+})
@@ -0,0 +1,114 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrTransactionConflict is returned by `Transaction` when a watched key kept
+// changing after all retries.
+var ErrTransactionConflict = errors.New("transaction aborted: watched keys changed")
+
+// TransactionConfiguration is the configuration for transactions.
+type TransactionConfiguration struct {
+	// MaxRetries is how many times a transaction is retried when a watched
+	// key changes. Defaults to 3, and a negative value disables the retries.
+	MaxRetries int `yaml:"max_retries"`
+}
+
+// Tx is a transaction being built by a `TxHandler`. Commands sent with `Read`
+// run immediately, while commands queued (as in a `Pipeline`) are sent inside
+// MULTI/EXEC and have their results resolved after EXEC.
+type Tx struct {
+	commandQueue
+	ctx  context.Context
+	conn redis.ConnWithTimeout
+}
+
+// TxHandler builds a transaction. It can be called more than once, as the
+// transaction is retried when a watched key changes.
+type TxHandler func(tx *Tx) error
+
+// Read sends a command immediately, before the transaction starts. It is
+// used to read the current values of the watched keys.
+func (tx *Tx) Read(commandName string, args ...interface{}) (interface{}, error) {
+	return doContext(tx.ctx, tx.conn, commandName, args...)
+}
+
+// Transaction WATCHes the keys and calls the handler to read their values and
+// queue the writes, which are then sent inside MULTI/EXEC. If a watched key
+// changes before EXEC, the transaction is retried (see
+// `TransactionConfiguration`) and, after all retries, `ErrTransactionConflict`
+// is returned.
+func (service *RedigoService) Transaction(ctx context.Context, watchKeys []string, handler TxHandler) error {
+	return service.RunWithConnContext(ctx, func(conn redis.ConnWithTimeout) error {
+		for attempt := 0; ; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			committed, err := service.runTransaction(ctx, conn, watchKeys, handler)
+			if err != nil || committed {
+				return err
+			}
+
+			// Increment to count conflicts
+			service.Collector.transactionConflicts.Inc()
+
+			if attempt >= service.Configuration.Transaction.MaxRetries {
+				return ErrTransactionConflict
+			}
+		}
+	})
+}
+
+// runTransaction runs a single attempt of the transaction. It returns false
+// when EXEC was aborted because a watched key changed.
+func (service *RedigoService) runTransaction(ctx context.Context, conn redis.ConnWithTimeout, watchKeys []string, handler TxHandler) (bool, error) {
+	if len(watchKeys) > 0 {
+		if _, err := doContext(ctx, conn, "WATCH", redis.Args{}.AddFlat(watchKeys)...); err != nil {
+			return false, err
+		}
+	}
+
+	tx := &Tx{ctx: ctx, conn: conn}
+	if err := handler(tx); err != nil {
+		conn.Do("UNWATCH")
+		return false, err
+	}
+	if len(tx.commands) == 0 {
+		_, err := conn.Do("UNWATCH")
+		return true, err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return false, err
+	}
+	for _, command := range tx.commands {
+		if err := conn.Send(command.name, command.args...); err != nil {
+			return false, err
+		}
+	}
+	replies, err := redis.Values(doContext(ctx, conn, "EXEC"))
+	if err == ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var firstErr error
+	for i, command := range tx.commands {
+		var err error
+		if replyErr, ok := replies[i].(redis.Error); ok {
+			err = replyErr
+		}
+		command.result.resolve(replies[i], err)
+		if err := command.result.Err(); firstErr == nil && err != nil && err != ErrNil {
+			firstErr = err
+		}
+	}
+	return true, firstErr
+}
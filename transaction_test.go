@@ -0,0 +1,115 @@
+package redigosrv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("RedigoService (Transaction)", func() {
+	var service RedigoService
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		Expect(service.ApplyConfiguration(Configuration{
+			Address: "localhost:6379",
+			Transaction: TransactionConfiguration{
+				MaxRetries: 2,
+			},
+		})).To(Succeed())
+		Expect(service.Start()).To(Succeed())
+		_, err := service.Del(ctx, "tx-counter", "tx-other")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		service.Stop()
+	})
+
+	It("should read the watched keys and queue writes", func() {
+		Expect(service.Set(ctx, "tx-counter", 10, 0)).To(Succeed())
+
+		var incr *IntResult
+		Expect(service.Transaction(ctx, []string{"tx-counter"}, func(tx *Tx) error {
+			counter, err := redis.Int64(tx.Read("GET", "tx-counter"))
+			if err != nil {
+				return err
+			}
+			incr = tx.IncrBy("tx-counter", counter)
+			tx.Set("tx-other", "value", 0)
+			return nil
+		})).To(Succeed())
+
+		Expect(incr.Result()).To(Equal(int64(20)))
+		Expect(service.Get(ctx, "tx-other")).To(Equal("value"))
+	})
+
+	It("should retry when a watched key changes", func() {
+		Expect(service.Set(ctx, "tx-counter", 1, 0)).To(Succeed())
+
+		attempts := 0
+		Expect(service.Transaction(ctx, []string{"tx-counter"}, func(tx *Tx) error {
+			attempts++
+			counter, err := redis.Int64(tx.Read("GET", "tx-counter"))
+			if err != nil {
+				return err
+			}
+			if attempts == 1 {
+				// Another client changes the watched key.
+				_, err := service.Incr(ctx, "tx-counter")
+				Expect(err).ToNot(HaveOccurred())
+			}
+			tx.Set("tx-counter", counter*10, 0)
+			return nil
+		})).To(Succeed())
+
+		Expect(attempts).To(Equal(2))
+		Expect(service.Get(ctx, "tx-counter")).To(Equal("20"))
+
+		var metric dto.Metric
+		Expect(service.Collector.transactionConflicts.Write(&metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(float64(1)))
+	})
+
+	It("should give up after the max retries", func() {
+		attempts := 0
+		err := service.Transaction(ctx, []string{"tx-counter"}, func(tx *Tx) error {
+			attempts++
+			_, err := service.Incr(ctx, "tx-counter")
+			Expect(err).ToNot(HaveOccurred())
+			tx.Incr("tx-other")
+			return nil
+		})
+		Expect(err).To(Equal(ErrTransactionConflict))
+		Expect(attempts).To(Equal(3))
+		Expect(service.Exists(ctx, "tx-other")).To(Equal(int64(0)))
+	})
+
+	It("should not retry when the retries are disabled", func() {
+		service.Configuration.Transaction.MaxRetries = -1
+
+		attempts := 0
+		err := service.Transaction(ctx, []string{"tx-counter"}, func(tx *Tx) error {
+			attempts++
+			_, err := service.Incr(ctx, "tx-counter")
+			Expect(err).ToNot(HaveOccurred())
+			tx.Incr("tx-other")
+			return nil
+		})
+		Expect(err).To(Equal(ErrTransactionConflict))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should propagate the error from the handler", func() {
+		err := service.Transaction(ctx, []string{"tx-counter"}, func(tx *Tx) error {
+			tx.Incr("tx-counter")
+			return errors.New("something bad")
+		})
+		Expect(err).To(MatchError("something bad"))
+		Expect(service.Exists(ctx, "tx-counter")).To(Equal(int64(0)))
+	})
+})